@@ -0,0 +1,573 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	devopsapi "github.com/NpoolDevOps/fbc-devops-service/devopsapi"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+const (
+	OutputTable = "table"
+	OutputJson  = "json"
+)
+
+// adminSession is what every admin subcommand works on: a devopsapi client
+// bound either to a remote server or to handlers running in-process against
+// the configured MySQL and Redis.
+type adminSession struct {
+	client   *devopsapi.Client
+	authCode string
+	output   string
+	out      io.Writer
+	close    func()
+}
+
+func adminFlags(output string) []cli.Flag {
+	operator := os.Getenv("USER")
+	if operator == "" {
+		operator = "admin"
+	}
+
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:  "remote",
+			Usage: "Base url of a devops server, the configured database is used directly when empty",
+		},
+		&cli.StringFlag{
+			Name:    "token",
+			Usage:   "API token for the remote server",
+			EnvVars: []string{"FBC_DEVOPS_TOKEN"},
+		},
+		&cli.StringFlag{
+			Name:    "auth-code",
+			Usage:   "Auth code for the remote server, needed by transfer to validate users",
+			EnvVars: []string{"FBC_DEVOPS_AUTH_CODE"},
+		},
+		&cli.StringFlag{
+			Name:  "operator",
+			Usage: "Operator recorded in the audit log when using the database directly",
+			Value: operator,
+		},
+		&cli.StringFlag{
+			Name:  "output",
+			Usage: "Output format, table or json",
+			Value: output,
+		},
+	}
+}
+
+func newAdminSession(cctx *cli.Context) (*adminSession, error) {
+	session := &adminSession{
+		output: cctx.String("output"),
+		out:    os.Stdout,
+		close:  func() {},
+	}
+	if session.output != OutputTable && session.output != OutputJson {
+		return nil, xerrors.Errorf("invalid output %v", session.output)
+	}
+
+	if cctx.String("remote") != "" {
+		client, err := devopsapi.NewClient(devopsapi.ClientConfig{
+			BaseURL: cctx.String("remote"),
+			Retries: 2,
+			Token:   cctx.String("token"),
+		})
+		if err != nil {
+			return nil, err
+		}
+		session.client = client
+		session.authCode = cctx.String("auth-code")
+		return session, nil
+	}
+
+	if cctx.String("operator") == "" {
+		return nil, xerrors.Errorf("operator is must")
+	}
+	auth := newOperatorAuth(cctx.String("operator"))
+
+	server, closeServer, err := newLocalServer(cctx.String("config"), auth)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := devopsapi.NewClient(devopsapi.ClientConfig{
+		BaseURL: "http://localhost",
+		HttpClient: &http.Client{
+			Transport: &localTransport{routers: server.routers()},
+		},
+	})
+	if err != nil {
+		closeServer()
+		return nil, err
+	}
+
+	session.client = client
+	session.authCode = auth.authCode
+	session.close = closeServer
+	return session, nil
+}
+
+func withAdminSession(action func(cctx *cli.Context, session *adminSession) error) cli.ActionFunc {
+	return func(cctx *cli.Context) error {
+		session, err := newAdminSession(cctx)
+		if err != nil {
+			return err
+		}
+		defer session.close()
+		return action(cctx, session)
+	}
+}
+
+func (session *adminSession) printJson(v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(session.out, string(b))
+	return err
+}
+
+func (session *adminSession) printTable(header []string, rows [][]string) error {
+	w := tabwriter.NewWriter(session.out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+func parseDeviceId(s string) (uuid.UUID, error) {
+	id, err := uuid.Parse(s)
+	if err != nil {
+		return uuid.Nil, xerrors.Errorf("invalid device id %v: %v", s, err)
+	}
+	return id, nil
+}
+
+func parseOptionalBool(cctx *cli.Context, name string) (*bool, error) {
+	if cctx.String(name) == "" {
+		return nil, nil
+	}
+	value, err := strconv.ParseBool(cctx.String(name))
+	if err != nil {
+		return nil, xerrors.Errorf("invalid %v: %v", name, err)
+	}
+	return &value, nil
+}
+
+var deviceTableHeader = []string{
+	"ID", "SPEC", "ROLE", "SUB ROLE", "OWNER", "CURRENT USER", "MANAGER", "MAINTAINING", "OFFLINE", "DECOMMISSIONED",
+}
+
+func deviceTableRow(device types.DeviceAttribute) []string {
+	return []string{
+		device.Id.String(),
+		device.Spec,
+		device.Role,
+		device.SubRole,
+		device.Owner,
+		device.CurrentUser,
+		device.Manager,
+		strconv.FormatBool(device.Maintaining),
+		strconv.FormatBool(device.Offline),
+		strconv.FormatBool(device.Decommissioned),
+	}
+}
+
+func (session *adminSession) printDevices(output *types.MyDevicesOutput) error {
+	if session.output == OutputJson {
+		return session.printJson(output)
+	}
+
+	rows := [][]string{}
+	for _, device := range output.Devices {
+		rows = append(rows, deviceTableRow(device))
+	}
+	err := session.printTable(deviceTableHeader, rows)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(session.out, "%v of %v devices\n", len(output.Devices), output.Total)
+	return err
+}
+
+var listCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List devices",
+	Flags: append(adminFlags(OutputTable),
+		&cli.StringFlag{Name: "role"},
+		&cli.StringFlag{Name: "sub-role"},
+		&cli.StringFlag{Name: "owner"},
+		&cli.StringFlag{Name: "parent-spec"},
+		&cli.StringFlag{Name: "os-spec"},
+		&cli.StringFlag{Name: "maintaining", Usage: "Filter by maintaining, true or false"},
+		&cli.StringFlag{Name: "offline", Usage: "Filter by offline, true or false"},
+		&cli.BoolFlag{Name: "all", Usage: "Include decommissioned devices"},
+		&cli.StringFlag{Name: "sort-by"},
+		&cli.BoolFlag{Name: "desc"},
+		&cli.IntFlag{Name: "page"},
+		&cli.IntFlag{Name: "limit", Usage: "Devices per page, all devices when 0"},
+	),
+	Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+		maintaining, err := parseOptionalBool(cctx, "maintaining")
+		if err != nil {
+			return err
+		}
+		offline, err := parseOptionalBool(cctx, "offline")
+		if err != nil {
+			return err
+		}
+
+		output, err := session.client.MyDevicesByAuth(cctx.Context, types.MyDevicesByAuthInput{
+			AuthCode: session.authCode,
+			DeviceListFilter: types.DeviceListFilter{
+				Page:                  cctx.Int("page"),
+				Limit:                 cctx.Int("limit"),
+				Role:                  cctx.String("role"),
+				SubRole:               cctx.String("sub-role"),
+				Owner:                 cctx.String("owner"),
+				ParentSpec:            cctx.String("parent-spec"),
+				OsSpec:                cctx.String("os-spec"),
+				Maintaining:           maintaining,
+				Offline:               offline,
+				IncludeDecommissioned: cctx.Bool("all"),
+				SortBy:                cctx.String("sort-by"),
+				SortDesc:              cctx.Bool("desc"),
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		return session.printDevices(output)
+	}),
+}
+
+var showCmd = &cli.Command{
+	Name:      "show",
+	Usage:     "Show a device with its recent reports and status changes",
+	ArgsUsage: "<device id>",
+	Flags: append(adminFlags(OutputTable),
+		&cli.IntFlag{Name: "history", Usage: "Number of reports and status changes to show"},
+	),
+	Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+		if cctx.NArg() != 1 {
+			return xerrors.Errorf("device id is must")
+		}
+		id, err := parseDeviceId(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		output, err := session.client.DeviceDetail(cctx.Context, types.DeviceDetailInput{
+			AuthCode:     session.authCode,
+			DeviceID:     id,
+			HistoryLimit: cctx.Int("history"),
+		})
+		if err != nil {
+			return err
+		}
+
+		if session.output == OutputJson {
+			return session.printJson(output)
+		}
+
+		device := output.Device
+		rows := [][]string{}
+		for i, value := range deviceTableRow(device) {
+			rows = append(rows, []string{deviceTableHeader[i], value})
+		}
+		rows = append(rows,
+			[]string{"PARENT SPEC", strings.Join(device.ParentSpec, ",")},
+			[]string{"OS SPEC", device.OsSpec},
+			[]string{"NVME", fmt.Sprintf("%v/%v", device.RuntimeNvmeCount, device.NvmeCount)},
+			[]string{"GPU", fmt.Sprintf("%v/%v", device.RuntimeGpuCount, device.GpuCount)},
+			[]string{"MEMORY", fmt.Sprintf("%v/%v", device.RuntimeMemoryCount, device.MemoryCount)},
+			[]string{"HDD", fmt.Sprintf("%v/%v", device.RuntimeHddCount, device.HddCount)},
+			[]string{"LOCAL ADDR", device.LocalAddr},
+			[]string{"PUBLIC ADDR", device.PublicAddr},
+		)
+		err = session.printTable([]string{"FIELD", "VALUE"}, rows)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(session.out)
+		rows = [][]string{}
+		for _, report := range output.Reports {
+			rows = append(rows, []string{
+				report.CreateTime.Format("2006-01-02 15:04:05"),
+				strconv.Itoa(report.NvmeCount),
+				strconv.Itoa(report.GpuCount),
+				strconv.Itoa(report.MemoryCount),
+				strconv.Itoa(report.HddCount),
+				report.LocalAddr,
+				report.PublicAddr,
+			})
+		}
+		err = session.printTable([]string{"REPORT TIME", "NVME", "GPU", "MEMORY", "HDD", "LOCAL ADDR", "PUBLIC ADDR"}, rows)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintln(session.out)
+		rows = [][]string{}
+		for _, change := range output.StatusChanges {
+			rows = append(rows, []string{
+				change.CreateTime.Format("2006-01-02 15:04:05"),
+				strconv.FormatBool(change.Offline),
+			})
+		}
+		return session.printTable([]string{"CHANGE TIME", "OFFLINE"}, rows)
+	}),
+}
+
+var maintainCmd = &cli.Command{
+	Name:      "maintain",
+	Usage:     "Put devices into or out of maintaining mode",
+	ArgsUsage: "[device id...]",
+	Flags: append(adminFlags(OutputTable),
+		&cli.BoolFlag{Name: "off", Usage: "Take the devices out of maintaining mode"},
+		&cli.StringFlag{Name: "role", Usage: "Select devices by role instead of ids"},
+		&cli.StringFlag{Name: "sub-role"},
+		&cli.StringFlag{Name: "parent-spec"},
+	),
+	Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+		input := types.MaintainingInput{
+			AuthCode:    session.authCode,
+			Maintaining: !cctx.Bool("off"),
+		}
+		for _, arg := range cctx.Args().Slice() {
+			id, err := parseDeviceId(arg)
+			if err != nil {
+				return err
+			}
+			input.DeviceIDs = append(input.DeviceIDs, id)
+		}
+		if cctx.String("role") != "" || cctx.String("sub-role") != "" || cctx.String("parent-spec") != "" {
+			input.Selector = &types.DeviceSelector{
+				Role:       cctx.String("role"),
+				SubRole:    cctx.String("sub-role"),
+				ParentSpec: cctx.String("parent-spec"),
+			}
+		}
+		if len(input.DeviceIDs) == 0 && input.Selector == nil {
+			return xerrors.Errorf("device ids or selector is must")
+		}
+
+		output, err := session.client.MaintainDevices(cctx.Context, input)
+		if output != nil {
+			var perr error
+			if session.output == OutputJson {
+				perr = session.printJson(output)
+			} else {
+				rows := [][]string{}
+				for _, result := range output.Results {
+					rows = append(rows, []string{
+						result.DeviceID.String(),
+						strconv.FormatBool(result.Success),
+						result.Error,
+					})
+				}
+				perr = session.printTable([]string{"ID", "SUCCESS", "ERROR"}, rows)
+			}
+			if err == nil {
+				err = perr
+			}
+		}
+		return err
+	}),
+}
+
+var transferCmd = &cli.Command{
+	Name:      "transfer",
+	Usage:     "Transfer the owner, current user or manager of a device",
+	ArgsUsage: "<device id>",
+	Flags: append(adminFlags(OutputTable),
+		&cli.StringFlag{Name: "owner"},
+		&cli.StringFlag{Name: "current-user"},
+		&cli.StringFlag{Name: "manager"},
+	),
+	Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+		if cctx.NArg() != 1 {
+			return xerrors.Errorf("device id is must")
+		}
+		id, err := parseDeviceId(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		output, err := session.client.TransferDevice(cctx.Context, types.DeviceTransferInput{
+			AuthCode:    session.authCode,
+			DeviceID:    id,
+			Owner:       cctx.String("owner"),
+			CurrentUser: cctx.String("current-user"),
+			Manager:     cctx.String("manager"),
+		})
+		if err != nil {
+			return err
+		}
+
+		ownerships, err := session.client.DeviceOwnerships(cctx.Context, types.DeviceOwnershipsInput{
+			AuthCode: session.authCode,
+			DeviceID: output.Id,
+		})
+		if err != nil {
+			return err
+		}
+
+		if session.output == OutputJson {
+			return session.printJson(ownerships)
+		}
+
+		rows := [][]string{}
+		for _, info := range ownerships.Ownerships {
+			rows = append(rows, []string{
+				info.CreateTime.Format("2006-01-02 15:04:05"),
+				info.Owner,
+				info.CurrentUser,
+				info.Manager,
+				info.Operator,
+			})
+		}
+		return session.printTable([]string{"TRANSFER TIME", "OWNER", "CURRENT USER", "MANAGER", "OPERATOR"}, rows)
+	}),
+}
+
+func (session *adminSession) printRoles(roles []types.DeviceRole) error {
+	if session.output == OutputJson {
+		return session.printJson(roles)
+	}
+
+	rows := [][]string{}
+	for _, role := range roles {
+		rows = append(rows, []string{role.Role, strings.Join(role.SubRoles, ",")})
+	}
+	return session.printTable([]string{"ROLE", "SUB ROLES"}, rows)
+}
+
+func roleInput(cctx *cli.Context, session *adminSession) (types.DeviceRoleInput, error) {
+	input := types.DeviceRoleInput{
+		AuthCode: session.authCode,
+	}
+	if cctx.NArg() != 1 {
+		return input, xerrors.Errorf("role is must")
+	}
+	input.Role = cctx.Args().First()
+	input.SubRoles = cctx.StringSlice("sub-role")
+	return input, nil
+}
+
+var rolesCmd = &cli.Command{
+	Name:  "roles",
+	Usage: "Manage device roles",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "list",
+			Usage: "List device roles",
+			Flags: adminFlags(OutputTable),
+			Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+				output, err := session.client.DeviceRoles(cctx.Context, types.DeviceRolesInput{
+					AuthCode: session.authCode,
+				})
+				if err != nil {
+					return err
+				}
+				return session.printRoles(output.Roles)
+			}),
+		},
+		{
+			Name:      "create",
+			Usage:     "Create a device role",
+			ArgsUsage: "<role>",
+			Flags: append(adminFlags(OutputTable),
+				&cli.StringSliceFlag{Name: "sub-role"},
+			),
+			Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+				input, err := roleInput(cctx, session)
+				if err != nil {
+					return err
+				}
+				role, err := session.client.CreateDeviceRole(cctx.Context, input)
+				if err != nil {
+					return err
+				}
+				return session.printRoles([]types.DeviceRole{*role})
+			}),
+		},
+		{
+			Name:      "update",
+			Usage:     "Replace the sub roles of a device role",
+			ArgsUsage: "<role>",
+			Flags: append(adminFlags(OutputTable),
+				&cli.StringSliceFlag{Name: "sub-role"},
+			),
+			Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+				input, err := roleInput(cctx, session)
+				if err != nil {
+					return err
+				}
+				role, err := session.client.UpdateDeviceRole(cctx.Context, input)
+				if err != nil {
+					return err
+				}
+				return session.printRoles([]types.DeviceRole{*role})
+			}),
+		},
+		{
+			Name:      "delete",
+			Usage:     "Delete a device role no device uses",
+			ArgsUsage: "<role>",
+			Flags:     adminFlags(OutputTable),
+			Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+				input, err := roleInput(cctx, session)
+				if err != nil {
+					return err
+				}
+				return session.client.DeleteDeviceRole(cctx.Context, input)
+			}),
+		},
+	},
+}
+
+var exportCmd = &cli.Command{
+	Name:  "export",
+	Usage: "Export the device inventory, decommissioned devices included",
+	Flags: append(adminFlags(OutputJson),
+		&cli.StringFlag{Name: "file", Usage: "Write to the file instead of stdout"},
+	),
+	Action: withAdminSession(func(cctx *cli.Context, session *adminSession) error {
+		output, err := session.client.MyDevicesByAuth(cctx.Context, types.MyDevicesByAuthInput{
+			AuthCode: session.authCode,
+			DeviceListFilter: types.DeviceListFilter{
+				IncludeDecommissioned: true,
+				SortBy:                "spec",
+			},
+		})
+		if err != nil {
+			return err
+		}
+
+		if cctx.String("file") != "" {
+			f, err := os.Create(cctx.String("file"))
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			session.out = f
+		}
+
+		return session.printDevices(output)
+	}),
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	devopsredis "github.com/NpoolDevOps/fbc-devops-service/redis"
+	httpdaemon "github.com/NpoolRD/http-daemon"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// operatorAuth trusts whoever runs the admin CLI against the database as a
+// super user, they hold the database credentials already. Usernames given to
+// transfer are not validated in this mode.
+type operatorAuth struct {
+	authCode string
+	user     authtypes.UserInfoOutput
+}
+
+func newOperatorAuth(username string) *operatorAuth {
+	return &operatorAuth{
+		authCode: uuid.New().String(),
+		user: authtypes.UserInfoOutput{
+			Id:        uuid.NewSHA1(uuid.NameSpaceOID, []byte(username)),
+			Username:  username,
+			SuperUser: true,
+		},
+	}
+}
+
+func (auth *operatorAuth) UserInfo(authCode string) (*authtypes.UserInfoOutput, error) {
+	if authCode != auth.authCode {
+		return nil, xerrors.Errorf("invalid auth code")
+	}
+	user := auth.user
+	return &user, nil
+}
+
+func (auth *operatorAuth) Login(username string, password string) (*authtypes.UserInfoOutput, error) {
+	return nil, xerrors.Errorf("login is not supported by the admin cli")
+}
+
+func (auth *operatorAuth) ValidateUsername(authCode string, username string) error {
+	_, err := auth.UserInfo(authCode)
+	return err
+}
+
+type localResponse struct {
+	header http.Header
+	status int
+}
+
+func (resp *localResponse) Header() http.Header {
+	return resp.header
+}
+
+func (resp *localResponse) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (resp *localResponse) WriteHeader(status int) {
+	resp.status = status
+}
+
+// localTransport lets devopsapi.Client call the handlers of an in-process
+// server, so that the admin CLI runs the same checks and audit whether it
+// talks to a remote server or straight to the database.
+type localTransport struct {
+	routers []httpdaemon.HttpRouter
+}
+
+func (transport *localTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var router *httpdaemon.HttpRouter
+	for i, r := range transport.routers {
+		if r.Location == req.URL.Path && r.Method == req.Method {
+			router = &transport.routers[i]
+			break
+		}
+	}
+	if router == nil {
+		return nil, xerrors.Errorf("invalid request %v / %v", req.URL, req.Method)
+	}
+
+	req = req.Clone(req.Context())
+	req.RemoteAddr = "admin-cli"
+	if req.Body == nil {
+		req.Body = ioutil.NopCloser(bytes.NewReader(nil))
+	}
+	err := req.ParseForm()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &localResponse{
+		header: http.Header{},
+		status: http.StatusOK,
+	}
+	body, msg, code := router.Handler(w, req)
+
+	b, err := json.Marshal(httpdaemon.ApiResp{
+		Code: code,
+		Msg:  msg,
+		Body: body,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        http.StatusText(w.status),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          ioutil.NopCloser(bytes.NewReader(b)),
+		ContentLength: int64(len(b)),
+		Request:       req,
+	}, nil
+}
+
+// newLocalServer builds a server on the configured MySQL and Redis without
+// starting the http daemon or the background watchers.
+func newLocalServer(configFile string, auth Authenticator) (*DevopsServer, func(), error) {
+	config, err := readDevopsConfig(configFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	redisCli := devopsredis.NewRedisCli(config.RedisCfg)
+	if redisCli == nil {
+		return nil, nil, xerrors.Errorf("cannot create redis client %v", config.RedisCfg.Host)
+	}
+
+	mysqlCli := devopsmysql.NewMysqlCli(config.MysqlCfg)
+	if mysqlCli == nil {
+		return nil, nil, xerrors.Errorf("cannot create mysql client %v", config.MysqlCfg.Host)
+	}
+
+	deviceIdResolver, err := newDeviceIdResolver(config)
+	if err != nil {
+		mysqlCli.Delete()
+		return nil, nil, err
+	}
+
+	server := newDevopsServer(config, mysqlCli, redisCli, nil, auth, deviceIdResolver)
+	return server, mysqlCli.Delete, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	devopsapi "github.com/NpoolDevOps/fbc-devops-service/devopsapi"
+	"github.com/NpoolDevOps/fbc-devops-service/idresolver"
+	"github.com/NpoolDevOps/fbc-devops-service/memstore"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+func newLocalSession(t *testing.T, output string) (*adminSession, *memstore.DeviceStore, *bytes.Buffer) {
+	store := memstore.NewDeviceStore()
+	auth := newOperatorAuth("ops")
+	server := newDevopsServer(DevopsConfig{}, store, memstore.NewRuntimeCache(), nil, auth, idresolver.NewLocal(uuid.Nil))
+
+	err := store.InsertDeviceRole("storage", nil)
+	if err != nil {
+		t.Fatalf("cannot insert role: %v", err)
+	}
+
+	client, err := devopsapi.NewClient(devopsapi.ClientConfig{
+		BaseURL: "http://localhost",
+		HttpClient: &http.Client{
+			Transport: &localTransport{routers: server.routers()},
+		},
+	})
+	if err != nil {
+		t.Fatalf("cannot create client: %v", err)
+	}
+
+	out := &bytes.Buffer{}
+	return &adminSession{
+		client:   client,
+		authCode: auth.authCode,
+		output:   output,
+		out:      out,
+		close:    func() {},
+	}, store, out
+}
+
+func TestAdminLocalSession(t *testing.T) {
+	session, store, out := newLocalSession(t, OutputTable)
+	ctx := context.Background()
+
+	ids := []uuid.UUID{}
+	for _, spec := range []string{"spec-0", "spec-1"} {
+		output, err := session.client.RegisterDevice(ctx, types.DeviceRegisterInput{
+			Spec:  spec,
+			Role:  "storage",
+			Owner: "alice",
+		})
+		if err != nil {
+			t.Fatalf("cannot register device: %v", err)
+		}
+		ids = append(ids, output.Id)
+	}
+
+	output, err := session.client.MyDevicesByAuth(ctx, types.MyDevicesByAuthInput{
+		AuthCode:         session.authCode,
+		DeviceListFilter: types.DeviceListFilter{SortBy: "spec"},
+	})
+	if err != nil {
+		t.Fatalf("cannot list devices: %v", err)
+	}
+	err = session.printDevices(output)
+	if err != nil {
+		t.Fatalf("cannot print devices: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], "ID ") ||
+		!strings.Contains(lines[1], "spec-0") || lines[3] != "2 of 2 devices" {
+		t.Fatalf("unexpected table\n%v", out.String())
+	}
+
+	maintained, err := session.client.MaintainDevices(ctx, types.MaintainingInput{
+		AuthCode:    session.authCode,
+		Maintaining: true,
+		DeviceIDs:   append(ids, uuid.New()),
+	})
+	if !devopsapi.IsCode(err, types.ErrBatchFailed) {
+		t.Fatalf("expect %v, got %v", types.ErrBatchFailed.Reason, err)
+	}
+	if maintained == nil || len(maintained.Results) != 3 {
+		t.Fatalf("unexpected results %v", maintained)
+	}
+
+	_, err = session.client.TransferDevice(ctx, types.DeviceTransferInput{
+		AuthCode: session.authCode,
+		DeviceID: ids[0],
+		Owner:    "bob",
+	})
+	if err != nil {
+		t.Fatalf("cannot transfer device: %v", err)
+	}
+	logs, _ := store.QueryAuditLogs(devopsmysql.AuditFilter{DeviceId: ids[0], Actor: "ops"})
+	if len(logs) != 1 || logs[0].Action != types.AuditActionTransfer || logs[0].SourceIp != "admin-cli" {
+		t.Fatalf("unexpected audit logs %v", logs)
+	}
+
+	_, err = session.client.DeviceDetail(ctx, types.DeviceDetailInput{AuthCode: "invalid", DeviceID: ids[0]})
+	if !devopsapi.IsCode(err, types.ErrUnauthenticated) {
+		t.Fatalf("expect %v, got %v", types.ErrUnauthenticated.Reason, err)
+	}
+}
+
+func TestAdminJsonOutput(t *testing.T) {
+	session, _, out := newLocalSession(t, OutputJson)
+
+	err := session.printRoles([]types.DeviceRole{{Role: "storage", SubRoles: []string{}}})
+	if err != nil {
+		t.Fatalf("cannot print roles: %v", err)
+	}
+
+	roles := []types.DeviceRole{}
+	err = json.Unmarshal(out.Bytes(), &roles)
+	if err != nil || len(roles) != 1 || roles[0].Role != "storage" {
+		t.Fatalf("unexpected output %v: %v", out.String(), err)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+const defaultAuditLogsLimit = 100
+
+const auditRedacted = "[redacted]"
+
+type deviceSecretAudit struct {
+	Secret    string    `json:"secret"`
+	IssueTime time.Time `json:"issue_time"`
+}
+
+// parseTrustedProxies accepts addresses and CIDRs, invalid entries are
+// logged and skipped so that they never widen the trust.
+func parseTrustedProxies(proxies []string) []*net.IPNet {
+	nets := []*net.IPNet{}
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			log.Errorf(log.Fields{}, "invalid trusted proxy %v: %v", proxy, err)
+			continue
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets
+}
+
+func (s *DevopsServer) trustedProxy(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range s.trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// sourceIp is the peer address, X-Forwarded-For is only honored when the peer
+// is a trusted proxy, then the last hop not added by a trusted proxy wins.
+func (s *DevopsServer) sourceIp(req *http.Request) string {
+	source, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		source = req.RemoteAddr
+	}
+
+	if !s.trustedProxy(source) {
+		return source
+	}
+
+	hops := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			break
+		}
+		source = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+	return source
+}
+
+func auditFields(state interface{}) (map[string]interface{}, error) {
+	fields := map[string]interface{}{}
+	if state == nil || reflect.ValueOf(state).Kind() == reflect.Ptr && reflect.ValueOf(state).IsNil() {
+		return fields, nil
+	}
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &fields)
+	return fields, err
+}
+
+func auditDiff(before interface{}, after interface{}) (map[string]types.AuditFieldDiff, error) {
+	beforeFields, err := auditFields(before)
+	if err != nil {
+		return nil, err
+	}
+
+	afterFields, err := auditFields(after)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := map[string]types.AuditFieldDiff{}
+	for key, val := range beforeFields {
+		if !reflect.DeepEqual(val, afterFields[key]) {
+			diff[key] = types.AuditFieldDiff{Before: val, After: afterFields[key]}
+		}
+	}
+	for key, val := range afterFields {
+		if _, ok := beforeFields[key]; !ok {
+			diff[key] = types.AuditFieldDiff{Before: nil, After: val}
+		}
+	}
+
+	return diff, nil
+}
+
+func (s *DevopsServer) deviceSnapshot(id uuid.UUID) interface{} {
+	info, err := s.deviceStore.QueryDeviceConfig(id)
+	if err != nil {
+		return nil
+	}
+	return deviceAttribute(*info, nil)
+}
+
+// secretSnapshot records when the device secret was issued, never the secret itself.
+func (s *DevopsServer) secretSnapshot(id uuid.UUID) interface{} {
+	info, err := s.deviceStore.QueryDeviceSecret(id)
+	if err != nil {
+		return nil
+	}
+	return deviceSecretAudit{Secret: auditRedacted, IssueTime: info.CreateTime}
+}
+
+func (s *DevopsServer) audit(req *http.Request, actor string, action string, deviceId uuid.UUID, before interface{}, after interface{}) {
+	s.recordAudit(s.sourceIp(req), actor, action, deviceId, before, after)
+}
+
+func (s *DevopsServer) recordAudit(source string, actor string, action string, deviceId uuid.UUID, before interface{}, after interface{}) {
+	diff, err := auditDiff(before, after)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to diff %v of %v: %v", action, deviceId, err)
+		return
+	}
+
+	b, _ := json.Marshal(diff)
+
+	err = s.deviceStore.InsertAuditLog(devopsmysql.AuditLog{
+		Actor:    actor,
+		Action:   action,
+		DeviceId: deviceId,
+		Diff:     string(b),
+		SourceIp: source,
+	})
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to audit %v by %v on %v: %v", action, actor, deviceId, err)
+	}
+}
+
+func (s *DevopsServer) AuditLogsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.AuditLogsInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	if !user.SuperUser {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	filter := devopsmysql.AuditFilter{
+		DeviceId: input.DeviceID,
+		Actor:    input.Username,
+		Limit:    input.Limit,
+	}
+	if filter.Limit <= 0 {
+		filter.Limit = defaultAuditLogsLimit
+	}
+	if input.Start > 0 {
+		filter.Start = time.Unix(input.Start, 0)
+	}
+	if input.End > 0 {
+		filter.End = time.Unix(input.End, 0)
+	}
+
+	infos, err := s.deviceStore.QueryAuditLogs(filter)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	output := types.AuditLogsOutput{
+		Logs: []types.AuditLog{},
+	}
+	for _, info := range infos {
+		diff := map[string]types.AuditFieldDiff{}
+		err = json.Unmarshal([]byte(info.Diff), &diff)
+		if err != nil {
+			log.Errorf(log.Fields{}, "invalid audit diff of %v: %v", info.Id, err)
+		}
+
+		output.Logs = append(output.Logs, types.AuditLog{
+			Id:         info.Id,
+			Actor:      info.Actor,
+			Action:     info.Action,
+			DeviceID:   info.DeviceId,
+			Diff:       diff,
+			SourceIp:   info.SourceIp,
+			CreateTime: info.CreateTime,
+		})
+	}
+
+	return output, "", 0
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	"github.com/NpoolDevOps/fbc-devops-service/authprovider"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+const (
+	AuthProviderFbcAuth = "fbc-auth"
+	AuthProviderStatic  = "static"
+)
+
+var defaultAuthAppId = uuid.MustParse("00000002-0002-0002-0002-000000000002")
+
+// Authenticator resolves auth codes and passwords into users, see authprovider
+// for the fbc-auth-service, static file and fake implementations. The auth
+// code given to ValidateUsername is the caller's one if any, providers which
+// need credentials for the lookup fall back to their own.
+type Authenticator interface {
+	UserInfo(authCode string) (*authtypes.UserInfoOutput, error)
+	Login(username string, password string) (*authtypes.UserInfoOutput, error)
+	ValidateUsername(authCode string, username string) error
+}
+
+// UserResolver is implemented by providers which look any user up without
+// credentials, API tokens then follow the current privileges of their owner.
+type UserResolver interface {
+	UserByName(username string) (*authtypes.UserInfoOutput, error)
+}
+
+var (
+	_ Authenticator = (*authprovider.FbcAuth)(nil)
+	_ Authenticator = (*authprovider.StaticFile)(nil)
+	_ Authenticator = (*authprovider.Fake)(nil)
+	_ UserResolver  = (*authprovider.StaticFile)(nil)
+	_ UserResolver  = (*authprovider.Fake)(nil)
+)
+
+func newAuthenticator(config DevopsConfig) (Authenticator, error) {
+	switch config.AuthProvider {
+	case "", AuthProviderFbcAuth:
+		appId := defaultAuthAppId
+		if config.AuthAppId != "" {
+			var err error
+			appId, err = uuid.Parse(config.AuthAppId)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid auth app id %v: %v", config.AuthAppId, err)
+			}
+		}
+		return authprovider.NewFbcAuth(appId, config.AuthServiceUsername, config.AuthServicePassword), nil
+	case AuthProviderStatic:
+		if config.AuthUsersFile == "" {
+			return nil, xerrors.Errorf("auth users file is must for %v provider", AuthProviderStatic)
+		}
+		return authprovider.NewStaticFile(config.AuthUsersFile)
+	}
+	return nil, xerrors.Errorf("invalid auth provider %v", config.AuthProvider)
+}
+
+// canAccessDevice is the ownership rule shared by every handler: super users
+// reach all devices, others the ones they own, use or manage.
+func canAccessDevice(user *authtypes.UserInfoOutput, config *devopsmysql.DeviceConfig) bool {
+	return user.SuperUser || config.Owner == user.Username ||
+		config.CurrentUser == user.Username || config.Manager == user.Username
+}
+
+// visibleDeviceFilter narrows filter to the devices canAccessDevice allows.
+func visibleDeviceFilter(user *authtypes.UserInfoOutput, filter devopsmysql.DeviceFilter) devopsmysql.DeviceFilter {
+	if !user.SuperUser {
+		filter.Username = user.Username
+	}
+	return filter
+}
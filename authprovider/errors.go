@@ -0,0 +1,9 @@
+package authprovider
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// ErrCredentialsRequired is returned when a provider needs an auth code to
+// look users up but got neither one nor a service account.
+var ErrCredentialsRequired = xerrors.New("credentials are required to look up users")
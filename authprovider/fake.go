@@ -0,0 +1,98 @@
+package authprovider
+
+import (
+	"sync"
+
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+type fakeUser struct {
+	info     authtypes.UserInfoOutput
+	password string
+}
+
+// Fake keeps users in memory for tests, every AddUser call returns an auth code
+// which stays valid until RevokeAuthCode.
+type Fake struct {
+	mutex sync.Mutex
+	users map[string]fakeUser
+	codes map[string]string
+}
+
+func NewFake() *Fake {
+	return &Fake{
+		users: map[string]fakeUser{},
+		codes: map[string]string{},
+	}
+}
+
+func (auth *Fake) AddUser(username string, password string, superUser bool) string {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+
+	auth.users[username] = fakeUser{
+		info: authtypes.UserInfoOutput{
+			Id:        uuid.New(),
+			Username:  username,
+			SuperUser: superUser,
+		},
+		password: password,
+	}
+
+	authCode := uuid.New().String()
+	auth.codes[authCode] = username
+	return authCode
+}
+
+func (auth *Fake) RevokeAuthCode(authCode string) {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+
+	delete(auth.codes, authCode)
+}
+
+func (auth *Fake) UserInfo(authCode string) (*authtypes.UserInfoOutput, error) {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+
+	username, ok := auth.codes[authCode]
+	if !ok {
+		return nil, xerrors.Errorf("invalid auth code")
+	}
+
+	info := auth.users[username].info
+	return &info, nil
+}
+
+func (auth *Fake) Login(username string, password string) (*authtypes.UserInfoOutput, error) {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+
+	user, ok := auth.users[username]
+	if !ok || user.password != password {
+		return nil, xerrors.Errorf("invalid username or password")
+	}
+
+	info := user.info
+	return &info, nil
+}
+
+func (auth *Fake) ValidateUsername(authCode string, username string) error {
+	_, err := auth.UserByName(username)
+	return err
+}
+
+func (auth *Fake) UserByName(username string) (*authtypes.UserInfoOutput, error) {
+	auth.mutex.Lock()
+	defer auth.mutex.Unlock()
+
+	user, ok := auth.users[username]
+	if !ok {
+		return nil, xerrors.Errorf("invalid user %v", username)
+	}
+
+	info := user.info
+	return &info, nil
+}
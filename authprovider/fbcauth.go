@@ -0,0 +1,73 @@
+package authprovider
+
+import (
+	authapi "github.com/NpoolDevOps/fbc-auth-service/authapi"
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// FbcAuth authenticates against fbc-auth-service, it is the default provider.
+// The optional service account looks users up for callers without auth code,
+// such as API token holders.
+type FbcAuth struct {
+	appId           uuid.UUID
+	serviceUsername string
+	servicePassword string
+}
+
+func NewFbcAuth(appId uuid.UUID, serviceUsername string, servicePassword string) *FbcAuth {
+	return &FbcAuth{
+		appId:           appId,
+		serviceUsername: serviceUsername,
+		servicePassword: servicePassword,
+	}
+}
+
+func (auth *FbcAuth) UserInfo(authCode string) (*authtypes.UserInfoOutput, error) {
+	return authapi.UserInfo(authtypes.UserInfoInput{
+		AuthCode: authCode,
+	})
+}
+
+func (auth *FbcAuth) Login(username string, password string) (*authtypes.UserInfoOutput, error) {
+	output, err := authapi.Login(authtypes.UserLoginInput{
+		Username: username,
+		Password: password,
+		AppId:    auth.appId,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return auth.UserInfo(output.AuthCode)
+}
+
+func (auth *FbcAuth) ValidateUsername(authCode string, username string) error {
+	if authCode == "" {
+		if auth.serviceUsername == "" {
+			return ErrCredentialsRequired
+		}
+		output, err := authapi.Login(authtypes.UserLoginInput{
+			Username: auth.serviceUsername,
+			Password: auth.servicePassword,
+			AppId:    auth.appId,
+		})
+		if err != nil {
+			return xerrors.Errorf("cannot login service account: %v", err)
+		}
+		authCode = output.AuthCode
+	}
+
+	info, err := authapi.UsernameInfo(authtypes.UsernameInfoInput{
+		AuthCode: authCode,
+		Username: username,
+	})
+	if err != nil {
+		return xerrors.Errorf("invalid user %v: %v", username, err)
+	}
+	if info.Username != username {
+		return xerrors.Errorf("invalid user %v", username)
+	}
+	return nil
+}
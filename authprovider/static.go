@@ -0,0 +1,141 @@
+package authprovider
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io/ioutil"
+
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/xerrors"
+)
+
+type StaticUser struct {
+	Id             uuid.UUID `json:"id"`
+	Username       string    `json:"username"`
+	PasswordBcrypt string    `json:"password_bcrypt"`
+	SuperUser      bool      `json:"super_user"`
+	VisitorOnly    bool      `json:"visitor_only"`
+	AuthCodes      []string  `json:"auth_codes"`
+}
+
+type StaticUsers struct {
+	Users []StaticUser `json:"users"`
+}
+
+// StaticFile serves the users listed in a local json file, for sites without
+// access to fbc-auth-service. Auth codes never expire, rotate them by editing
+// the file and restarting the service.
+type StaticFile struct {
+	users map[string]StaticUser
+}
+
+func NewStaticFile(path string) (*StaticFile, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	users := StaticUsers{}
+	err = json.Unmarshal(b, &users)
+	if err != nil {
+		return nil, xerrors.Errorf("cannot parse %v: %v", path, err)
+	}
+
+	return NewStatic(users.Users)
+}
+
+func NewStatic(users []StaticUser) (*StaticFile, error) {
+	auth := &StaticFile{
+		users: map[string]StaticUser{},
+	}
+
+	codes := map[string]struct{}{}
+	for _, user := range users {
+		if user.Username == "" {
+			return nil, xerrors.Errorf("username is must")
+		}
+		if _, ok := auth.users[user.Username]; ok {
+			return nil, xerrors.Errorf("duplicated user %v", user.Username)
+		}
+		for _, code := range user.AuthCodes {
+			if code == "" {
+				return nil, xerrors.Errorf("empty auth code of %v", user.Username)
+			}
+			if _, ok := codes[code]; ok {
+				return nil, xerrors.Errorf("duplicated auth code of %v", user.Username)
+			}
+			codes[code] = struct{}{}
+		}
+		if user.Id == uuid.Nil {
+			user.Id = uuid.NewSHA1(uuid.NameSpaceOID, []byte(user.Username))
+		}
+		auth.users[user.Username] = user
+	}
+
+	return auth, nil
+}
+
+// HashPassword returns the password_bcrypt value of a static user.
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func userInfo(user StaticUser) *authtypes.UserInfoOutput {
+	return &authtypes.UserInfoOutput{
+		Id:          user.Id,
+		Username:    user.Username,
+		SuperUser:   user.SuperUser,
+		VisitorOnly: user.VisitorOnly,
+	}
+}
+
+func (auth *StaticFile) UserInfo(authCode string) (*authtypes.UserInfoOutput, error) {
+	if authCode == "" {
+		return nil, xerrors.Errorf("auth code is must")
+	}
+
+	for _, user := range auth.users {
+		for _, code := range user.AuthCodes {
+			if subtle.ConstantTimeCompare([]byte(code), []byte(authCode)) == 1 {
+				return userInfo(user), nil
+			}
+		}
+	}
+
+	return nil, xerrors.Errorf("invalid auth code")
+}
+
+func (auth *StaticFile) Login(username string, password string) (*authtypes.UserInfoOutput, error) {
+	user, ok := auth.users[username]
+	if !ok || user.PasswordBcrypt == "" {
+		return nil, xerrors.Errorf("invalid username or password")
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(user.PasswordBcrypt), []byte(password))
+	if err != nil {
+		return nil, xerrors.Errorf("invalid username or password")
+	}
+
+	return userInfo(user), nil
+}
+
+// ValidateUsername needs no credentials, the callers are authenticated by the
+// server already.
+func (auth *StaticFile) ValidateUsername(authCode string, username string) error {
+	_, err := auth.UserByName(username)
+	return err
+}
+
+func (auth *StaticFile) UserByName(username string) (*authtypes.UserInfoOutput, error) {
+	user, ok := auth.users[username]
+	if !ok {
+		return nil, xerrors.Errorf("invalid user %v", username)
+	}
+	return userInfo(user), nil
+}
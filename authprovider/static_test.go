@@ -0,0 +1,32 @@
+package authprovider
+
+import (
+	"testing"
+)
+
+func TestStaticLogin(t *testing.T) {
+	hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatalf("cannot hash password: %v", err)
+	}
+
+	auth, err := NewStatic([]StaticUser{
+		{Username: "carol", PasswordBcrypt: hash, SuperUser: true},
+		{Username: "dave"},
+	})
+	if err != nil {
+		t.Fatalf("cannot create static provider: %v", err)
+	}
+
+	user, err := auth.Login("carol", "secret")
+	if err != nil || user.Username != "carol" || !user.SuperUser {
+		t.Fatalf("unexpected login %v: %v", user, err)
+	}
+
+	for _, login := range [][2]string{{"carol", "wrong"}, {"dave", ""}, {"nobody", "secret"}} {
+		_, err = auth.Login(login[0], login[1])
+		if err == nil {
+			t.Fatalf("expect %v to fail login", login[0])
+		}
+	}
+}
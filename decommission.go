@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/EntropyPool/entropy-logger"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+func (s *DevopsServer) DeviceDecommissionRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceDecommissionInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	if input.DeviceID == uuid.Nil {
+		return fail(w, types.ErrInvalidParam, "device id is must")
+	}
+
+	if input.Reason == "" {
+		return fail(w, types.ErrInvalidParam, "reason is must")
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	if !user.SuperUser {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	before := s.deviceSnapshot(input.DeviceID)
+
+	err = s.deviceStore.DecommissionDevice(input.DeviceID, input.Reason, user.Username)
+	if err != nil {
+		return fail(w, types.ErrConflict, err.Error())
+	}
+
+	s.audit(req, user.Username, types.AuditActionDecommission,
+		input.DeviceID, before, s.deviceSnapshot(input.DeviceID))
+
+	s.cancelMaintenanceWindows(req, user.Username, input.DeviceID)
+
+	err = s.runtimeCache.DeleteKeyInfo("device", input.DeviceID)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to delete cache of %v: %v", input.DeviceID, err)
+	}
+
+	output := types.DeviceCommonOutput{}
+	output.Id = input.DeviceID
+
+	return output, "", 0
+}
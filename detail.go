@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultDetailHistoryLimit = 20
+	maxDetailHistoryLimit     = 500
+)
+
+func (s *DevopsServer) DeviceDetailGetRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	query := req.URL.Query()
+
+	input := types.DeviceDetailInput{
+		AuthCode: query.Get("auth_code"),
+	}
+
+	if query.Get("device_id") != "" {
+		id, err := uuid.Parse(query.Get("device_id"))
+		if err != nil {
+			return fail(w, types.ErrInvalidParam, err.Error())
+		}
+		input.DeviceID = id
+	}
+
+	if query.Get("history_limit") != "" {
+		limit, err := strconv.Atoi(query.Get("history_limit"))
+		if err != nil {
+			return fail(w, types.ErrInvalidParam, err.Error())
+		}
+		input.HistoryLimit = limit
+	}
+
+	return s.deviceDetail(w, req, input)
+}
+
+func (s *DevopsServer) DeviceDetailPostRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceDetailInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	return s.deviceDetail(w, req, input)
+}
+
+func (s *DevopsServer) deviceDetail(w http.ResponseWriter, req *http.Request, input types.DeviceDetailInput) (interface{}, string, int) {
+	if input.DeviceID == uuid.Nil {
+		return fail(w, types.ErrInvalidParam, "device id is must")
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	config, err := s.deviceStore.QueryDeviceConfig(input.DeviceID)
+	if err != nil {
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if !canAccessDevice(user, config) {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	limit := input.HistoryLimit
+	if limit <= 0 {
+		limit = defaultDetailHistoryLimit
+	}
+	if limit > maxDetailHistoryLimit {
+		limit = maxDetailHistoryLimit
+	}
+
+	device, _ := s.runtimeCache.QueryDevice(config.Id)
+
+	reports, err := s.deviceStore.QueryDeviceReports(config.Id, limit)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	changes, err := s.deviceStore.QueryDeviceStatusChanges(config.Id, limit)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	output := types.DeviceDetailOutput{
+		Device:        deviceAttribute(*config, device),
+		Reports:       []types.DeviceReportRecord{},
+		StatusChanges: []types.DeviceStatusChange{},
+	}
+
+	for _, report := range reports {
+		record := types.DeviceReportRecord{}
+		record.Id = report.DeviceId
+		record.NvmeCount = report.NvmeCount
+		record.GpuCount = report.GpuCount
+		record.MemoryCount = report.MemoryCount
+		record.MemorySize = report.MemorySize
+		record.HddCount = report.HddCount
+		record.LocalAddr = report.LocalAddr
+		record.PublicAddr = report.PublicAddr
+		record.CreateTime = report.CreateTime
+		output.Reports = append(output.Reports, record)
+	}
+
+	for _, change := range changes {
+		output.StatusChanges = append(output.StatusChanges, types.DeviceStatusChange{
+			Offline:    change.Offline,
+			CreateTime: change.CreateTime,
+		})
+	}
+
+	return output, "", 0
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	devopsapi "github.com/NpoolDevOps/fbc-devops-service/devopsapi"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+const (
+	deviceSignatureWindow = 5 * time.Minute
+	maxDeviceNonceLength  = 64
+)
+
+func hasDeviceSignature(req *http.Request) bool {
+	return req.Header.Get(devopsapi.DeviceSignatureHeader) != ""
+}
+
+func (s *DevopsServer) verifyDeviceRequest(req *http.Request, id uuid.UUID, body []byte) error {
+	secret, err := s.deviceStore.QueryDeviceSecret(id)
+	if err != nil {
+		return xerrors.Errorf("no secret issued to device %v", id)
+	}
+
+	timestamp, err := strconv.ParseInt(req.Header.Get(devopsapi.DeviceTimestampHeader), 10, 64)
+	if err != nil {
+		return xerrors.Errorf("invalid timestamp: %v", err)
+	}
+
+	skew := time.Since(time.Unix(timestamp, 0))
+	if skew > deviceSignatureWindow || skew < -deviceSignatureWindow {
+		return xerrors.Errorf("timestamp out of window")
+	}
+
+	nonce := req.Header.Get(devopsapi.DeviceNonceHeader)
+	if nonce == "" || len(nonce) > maxDeviceNonceLength {
+		return xerrors.Errorf("invalid nonce")
+	}
+
+	signature, err := hex.DecodeString(req.Header.Get(devopsapi.DeviceSignatureHeader))
+	if err != nil {
+		return xerrors.Errorf("invalid signature: %v", err)
+	}
+
+	expected, _ := hex.DecodeString(devopsapi.SignDeviceRequest(secret.Secret,
+		req.Method, req.URL.Path, timestamp, nonce, body))
+	if !hmac.Equal(signature, expected) {
+		return xerrors.Errorf("signature mismatch")
+	}
+
+	fresh, err := s.runtimeCache.InsertNonce(id, nonce, 2*deviceSignatureWindow)
+	if err != nil {
+		return err
+	}
+	if !fresh {
+		return xerrors.Errorf("replayed request")
+	}
+
+	return nil
+}
+
+// authorizeDeviceRegister tells whether a secret has to be issued with the
+// registration. Only a new device gets its secret at registration, devices
+// registered before secrets existed and lost secrets get one from an operator
+// through DeviceSecretRotateRequest, anyone knowing the spec could claim it
+// otherwise.
+func (s *DevopsServer) authorizeDeviceRegister(req *http.Request, id uuid.UUID, registered bool, body []byte) (bool, error) {
+	_, err := s.deviceStore.QueryDeviceSecret(id)
+	if err != nil {
+		if !registered {
+			return true, nil
+		}
+		if s.config.AllowUnsignedDevice {
+			return false, nil
+		}
+		return false, xerrors.Errorf("no secret issued to device %v, ask an operator to rotate it", id)
+	}
+
+	if hasDeviceSignature(req) {
+		return false, s.verifyDeviceRequest(req, id, body)
+	}
+
+	if s.config.AllowUnsignedDevice {
+		return false, nil
+	}
+
+	return false, xerrors.Errorf("device request is not signed")
+}
+
+func (s *DevopsServer) authorizeDeviceReport(req *http.Request, id uuid.UUID, body []byte) error {
+	if hasDeviceSignature(req) {
+		return s.verifyDeviceRequest(req, id, body)
+	}
+
+	if s.config.AllowUnsignedDevice {
+		return nil
+	}
+
+	return xerrors.Errorf("device request is not signed")
+}
+
+func (s *DevopsServer) issueDeviceSecret(id uuid.UUID) (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	secret := hex.EncodeToString(b)
+	err = s.deviceStore.UpdateDeviceSecret(id, secret)
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+func (s *DevopsServer) DeviceSecretRotateRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceSecretRotateInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	if input.DeviceID == uuid.Nil {
+		return fail(w, types.ErrInvalidParam, "device id is must")
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	config, err := s.deviceStore.QueryDeviceConfig(input.DeviceID)
+	if err != nil {
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if !user.SuperUser && config.Owner != user.Username {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	if config.Decommissioned {
+		return fail(w, types.ErrDeviceDecommissioned, "device is decommissioned")
+	}
+
+	output := types.DeviceSecretRotateOutput{}
+	output.Id = input.DeviceID
+	before := s.secretSnapshot(input.DeviceID)
+	output.Secret, err = s.issueDeviceSecret(input.DeviceID)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	log.Infof(log.Fields{}, "secret of device %v rotated by %v", input.DeviceID, user.Username)
+	s.audit(req, user.Username, types.AuditActionSecretRotate, input.DeviceID, before, s.secretSnapshot(input.DeviceID))
+
+	return output, "", 0
+}
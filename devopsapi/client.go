@@ -0,0 +1,448 @@
+package devopsapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+const (
+	defaultTimeout    = 30 * time.Second
+	defaultBackoff    = 500 * time.Millisecond
+	defaultMaxBackoff = 10 * time.Second
+)
+
+type ClientConfig struct {
+	BaseURL string
+	// Timeout bounds a single attempt, the whole call is bounded by the context.
+	Timeout time.Duration
+	// Retries is the number of extra attempts made on transport errors and 5xx
+	// responses of read-only routes, writes are attempted once.
+	Retries    int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Token is sent as bearer token, it replaces the auth code of the inputs.
+	Token string
+	// DeviceSecret signs register and report calls when set.
+	DeviceSecret string
+	HttpClient   *http.Client
+}
+
+type Client struct {
+	config     ClientConfig
+	httpClient *http.Client
+}
+
+func NewClient(config ClientConfig) (*Client, error) {
+	if config.BaseURL == "" {
+		return nil, xerrors.Errorf("base url is must")
+	}
+	_, err := url.Parse(config.BaseURL)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid base url: %v", err)
+	}
+	config.BaseURL = strings.TrimRight(config.BaseURL, "/")
+
+	if config.Timeout <= 0 {
+		config.Timeout = defaultTimeout
+	}
+	if config.Retries < 0 {
+		config.Retries = 0
+	}
+	if config.Backoff <= 0 {
+		config.Backoff = defaultBackoff
+	}
+	if config.MaxBackoff < config.Backoff {
+		config.MaxBackoff = defaultMaxBackoff
+		if config.MaxBackoff < config.Backoff {
+			config.MaxBackoff = config.Backoff
+		}
+	}
+
+	httpClient := config.HttpClient
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+
+	return &Client{
+		config:     config,
+		httpClient: httpClient,
+	}, nil
+}
+
+type route struct {
+	method     string
+	path       string
+	idempotent bool
+	signed     bool
+}
+
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", xerrors.Errorf("cannot generate nonce: %v", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (cli *Client) backoff(attempt int) time.Duration {
+	backoff := cli.config.Backoff
+	for i := 0; i < attempt && backoff < cli.config.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > cli.config.MaxBackoff {
+		backoff = cli.config.MaxBackoff
+	}
+	return backoff
+}
+
+func (cli *Client) attempt(ctx context.Context, r route, query url.Values, body []byte, out interface{}) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, cli.config.Timeout)
+	defer cancel()
+
+	target := cli.config.BaseURL + r.path
+	if len(query) > 0 {
+		target = target + "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(r.method, target, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if cli.config.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+cli.config.Token)
+	}
+	if r.signed && cli.config.DeviceSecret != "" {
+		timestamp := time.Now().Unix()
+		nonce, err := newNonce()
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set(DeviceTimestampHeader, fmt.Sprintf("%v", timestamp))
+		req.Header.Set(DeviceNonceHeader, nonce)
+		req.Header.Set(DeviceSignatureHeader, SignDeviceRequest(cli.config.DeviceSecret,
+			r.method, r.path, timestamp, nonce, body))
+	}
+
+	resp, err := cli.httpClient.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return true, err
+	}
+
+	err = parseResponse(resp.StatusCode, b, out)
+	return resp.StatusCode >= 500, err
+}
+
+func (cli *Client) call(ctx context.Context, r route, query url.Values, input interface{}, out interface{}) error {
+	var body []byte
+	var err error
+
+	if input != nil {
+		body, err = json.Marshal(input)
+		if err != nil {
+			return err
+		}
+	}
+
+	retries := 0
+	if r.idempotent {
+		retries = cli.config.Retries
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryable, err := cli.attempt(ctx, r, query, body, out)
+		if err == nil || !retryable || attempt >= retries || ctx.Err() != nil {
+			return err
+		}
+
+		backoff := cli.backoff(attempt)
+		log.Infof(log.Fields{}, "retry %v %v in %v: %v", r.method, r.path, backoff, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// post is for routes changing state, they are never retried since the
+// server cannot tell a retry from a second request.
+func (cli *Client) post(ctx context.Context, path string, input interface{}, out interface{}) error {
+	return cli.call(ctx, route{method: "POST", path: path}, nil, input, out)
+}
+
+// query is for read-only routes which are safe to retry.
+func (cli *Client) query(ctx context.Context, path string, input interface{}, out interface{}) error {
+	return cli.call(ctx, route{method: "POST", path: path, idempotent: true}, nil, input, out)
+}
+
+func (cli *Client) RegisterDevice(ctx context.Context, input types.DeviceRegisterInput) (*types.DeviceRegisterOutput, error) {
+	output := types.DeviceRegisterOutput{}
+	err := cli.call(ctx, route{method: "POST", path: types.DeviceRegisterAPI, signed: true}, nil, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) ReportDevice(ctx context.Context, input types.DeviceReportInput) error {
+	return cli.call(ctx, route{method: "POST", path: types.DeviceReportAPI, signed: true}, nil, input, nil)
+}
+
+// MaintainDevices returns the per device results together with the error when
+// the batch is rolled back.
+func (cli *Client) MaintainDevices(ctx context.Context, input types.MaintainingInput) (*types.MaintainingOutput, error) {
+	output := types.MaintainingOutput{}
+	err := cli.post(ctx, types.DeviceMaintainAPI, input, &output)
+	if err != nil {
+		if output.Results != nil {
+			return &output, err
+		}
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) MyDevices(ctx context.Context, input types.MyDevicesByAuthInput) (*types.MyDevicesOutput, error) {
+	output := types.MyDevicesOutput{}
+	err := cli.query(ctx, types.MyDevicesAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) MyDevicesByAuth(ctx context.Context, input types.MyDevicesByAuthInput) (*types.MyDevicesOutput, error) {
+	output := types.MyDevicesOutput{}
+	err := cli.query(ctx, types.MyDevicesByAuthAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) MyDevicesByUsername(ctx context.Context, input types.MyDevicesByUsernameInput) (*types.MyDevicesOutput, error) {
+	output := types.MyDevicesOutput{}
+	err := cli.query(ctx, types.MyDevicesByUsernameAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) AlertMgrAddress(ctx context.Context, input types.DevopsAlertMgrAddressGetInput) (*types.DevopsAlertMgrAddressGetOutput, error) {
+	query := url.Values{}
+	if input.AuthCode != "" {
+		query.Set("auth_code", input.AuthCode)
+	}
+	if input.Id != uuid.Nil {
+		query.Set("id", input.Id.String())
+	}
+	if input.Role != "" {
+		query.Set("role", input.Role)
+	}
+	if input.SubRole != "" {
+		query.Set("sub_role", input.SubRole)
+	}
+	if len(input.ParentSpecs) > 0 {
+		query.Set("parent_spec", strings.Join(input.ParentSpecs, ","))
+	}
+
+	output := types.DevopsAlertMgrAddressGetOutput{}
+	err := cli.call(ctx, route{method: "GET", path: types.DevopsAlertMgrAddressAPI, idempotent: true}, query, nil, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) SetAlertMgrAddress(ctx context.Context, input types.DevopsAlertMgrAddressPostInput) (*types.DevopsAlertMgrAddressPostOutput, error) {
+	output := types.DevopsAlertMgrAddressPostOutput{}
+	err := cli.post(ctx, types.DevopsAlertMgrAddressAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) DevicesMetrics(ctx context.Context, input types.MetricInput) (*types.MetricOutput, error) {
+	output := types.MetricOutput{}
+	err := cli.query(ctx, types.MyDevicesMetricsAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) DeviceDrifts(ctx context.Context, input types.DeviceDriftsInput) (*types.DeviceDriftsOutput, error) {
+	output := types.DeviceDriftsOutput{}
+	err := cli.query(ctx, types.DeviceDriftsAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) RotateDeviceSecret(ctx context.Context, input types.DeviceSecretRotateInput) (*types.DeviceSecretRotateOutput, error) {
+	output := types.DeviceSecretRotateOutput{}
+	err := cli.post(ctx, types.DeviceSecretRotateAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) DecommissionDevice(ctx context.Context, input types.DeviceDecommissionInput) (*types.DeviceCommonOutput, error) {
+	output := types.DeviceCommonOutput{}
+	err := cli.post(ctx, types.DeviceDecommissionAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) TransferDevice(ctx context.Context, input types.DeviceTransferInput) (*types.DeviceCommonOutput, error) {
+	output := types.DeviceCommonOutput{}
+	err := cli.post(ctx, types.DeviceTransferAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) DeviceOwnerships(ctx context.Context, input types.DeviceOwnershipsInput) (*types.DeviceOwnershipsOutput, error) {
+	output := types.DeviceOwnershipsOutput{}
+	err := cli.query(ctx, types.DeviceOwnershipsAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) DeviceRoles(ctx context.Context, input types.DeviceRolesInput) (*types.DeviceRolesOutput, error) {
+	output := types.DeviceRolesOutput{}
+	err := cli.query(ctx, types.DeviceRolesAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) CreateDeviceRole(ctx context.Context, input types.DeviceRoleInput) (*types.DeviceRole, error) {
+	output := types.DeviceRole{}
+	err := cli.post(ctx, types.DeviceRoleCreateAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) UpdateDeviceRole(ctx context.Context, input types.DeviceRoleInput) (*types.DeviceRole, error) {
+	output := types.DeviceRole{}
+	err := cli.post(ctx, types.DeviceRoleUpdateAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) DeleteDeviceRole(ctx context.Context, input types.DeviceRoleInput) error {
+	return cli.post(ctx, types.DeviceRoleDeleteAPI, input, nil)
+}
+
+func (cli *Client) DeviceTopology(ctx context.Context, input types.DeviceTopologyInput) (*types.DeviceTopologyOutput, error) {
+	output := types.DeviceTopologyOutput{}
+	err := cli.query(ctx, types.DeviceTopologyAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) DeviceDetail(ctx context.Context, input types.DeviceDetailInput) (*types.DeviceDetailOutput, error) {
+	output := types.DeviceDetailOutput{}
+	err := cli.query(ctx, types.DeviceDetailAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) CreateApiToken(ctx context.Context, input types.ApiTokenCreateInput) (*types.ApiTokenCreateOutput, error) {
+	output := types.ApiTokenCreateOutput{}
+	err := cli.post(ctx, types.ApiTokenCreateAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) ApiTokens(ctx context.Context, input types.ApiTokensInput) (*types.ApiTokensOutput, error) {
+	output := types.ApiTokensOutput{}
+	err := cli.query(ctx, types.ApiTokensAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) RevokeApiToken(ctx context.Context, input types.ApiTokenRevokeInput) error {
+	return cli.post(ctx, types.ApiTokenRevokeAPI, input, nil)
+}
+
+func (cli *Client) AuditLogs(ctx context.Context, input types.AuditLogsInput) (*types.AuditLogsOutput, error) {
+	output := types.AuditLogsOutput{}
+	err := cli.query(ctx, types.AuditLogsAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) CreateMaintenance(ctx context.Context, input types.MaintenanceCreateInput) (*types.MaintenanceWindow, error) {
+	output := types.MaintenanceWindow{}
+	err := cli.post(ctx, types.MaintenanceCreateAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
+
+func (cli *Client) CancelMaintenance(ctx context.Context, input types.MaintenanceCancelInput) error {
+	return cli.post(ctx, types.MaintenanceCancelAPI, input, nil)
+}
+
+func (cli *Client) Maintenances(ctx context.Context, input types.MaintenancesInput) (*types.MaintenancesOutput, error) {
+	output := types.MaintenancesOutput{}
+	err := cli.query(ctx, types.MaintenancesAPI, input, &output)
+	if err != nil {
+		return nil, err
+	}
+	return &output, nil
+}
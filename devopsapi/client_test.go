@@ -0,0 +1,288 @@
+package devopsapi
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+func writeResponse(w http.ResponseWriter, status int, body interface{}, msg string, code int) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"code": code,
+		"msg":  msg,
+		"body": body,
+	})
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc, config ClientConfig) (*Client, func()) {
+	server := httptest.NewServer(handler)
+	config.BaseURL = server.URL + "/"
+	if config.Backoff == 0 {
+		config.Backoff = time.Millisecond
+	}
+	cli, err := NewClient(config)
+	if err != nil {
+		server.Close()
+		t.Fatalf("cannot create client: %v", err)
+	}
+	return cli, server.Close
+}
+
+func TestNewClientBaseURL(t *testing.T) {
+	_, err := NewClient(ClientConfig{})
+	if err == nil {
+		t.Fatalf("expect error without base url")
+	}
+}
+
+func TestMyDevicesByAuth(t *testing.T) {
+	id := uuid.New()
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != "POST" || req.URL.Path != types.MyDevicesByAuthAPI {
+			t.Errorf("unexpected request %v %v", req.Method, req.URL.Path)
+		}
+		if req.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected authorization %v", req.Header.Get("Authorization"))
+		}
+
+		input := types.MyDevicesByAuthInput{}
+		b, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(b, &input)
+		if input.Role != "worker" || input.Limit != 10 {
+			t.Errorf("unexpected input %v", input)
+		}
+
+		output := types.MyDevicesOutput{Total: 1}
+		output.Devices = append(output.Devices, types.DeviceAttribute{})
+		output.Devices[0].Id = id
+		writeResponse(w, 200, output, "", 0)
+	}, ClientConfig{Token: "token"})
+	defer done()
+
+	input := types.MyDevicesByAuthInput{}
+	input.Role = "worker"
+	input.Limit = 10
+
+	output, err := cli.MyDevicesByAuth(context.Background(), input)
+	if err != nil {
+		t.Fatalf("cannot query devices: %v", err)
+	}
+	if output.Total != 1 || len(output.Devices) != 1 || output.Devices[0].Id != id {
+		t.Fatalf("unexpected output %v", output)
+	}
+}
+
+func TestTypedError(t *testing.T) {
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		writeResponse(w, types.ErrNotFound.HttpStatus, nil, "record not found", types.ErrNotFound.Code)
+	}, ClientConfig{Retries: 3})
+	defer done()
+
+	_, err := cli.DeviceDetail(context.Background(), types.DeviceDetailInput{DeviceID: uuid.New()})
+	if !IsCode(err, types.ErrNotFound) {
+		t.Fatalf("expect %v, got %v", types.ErrNotFound.Reason, err)
+	}
+
+	apiErr, ok := AsError(err)
+	if !ok || apiErr.HttpStatus != 404 || apiErr.Msg != "record not found" {
+		t.Fatalf("unexpected error %v", apiErr)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	var calls int32
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			writeResponse(w, types.ErrStorage.HttpStatus, nil, "busy", types.ErrStorage.Code)
+			return
+		}
+		writeResponse(w, 200, types.DeviceRolesOutput{
+			Roles: []types.DeviceRole{{Role: "worker"}},
+		}, "", 0)
+	}, ClientConfig{Retries: 2})
+	defer done()
+
+	output, err := cli.DeviceRoles(context.Background(), types.DeviceRolesInput{})
+	if err != nil {
+		t.Fatalf("cannot query roles: %v", err)
+	}
+	if len(output.Roles) != 1 || calls != 3 {
+		t.Fatalf("unexpected output %v after %v calls", output, calls)
+	}
+}
+
+func TestNoRetryOnClientError(t *testing.T) {
+	var calls int32
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		writeResponse(w, 403, nil, "permission denied", types.ErrPermissionDenied.Code)
+	}, ClientConfig{Retries: 3})
+	defer done()
+
+	err := cli.CancelMaintenance(context.Background(), types.MaintenanceCancelInput{})
+	if !IsCode(err, types.ErrPermissionDenied) || calls != 1 {
+		t.Fatalf("unexpected error %v after %v calls", err, calls)
+	}
+}
+
+func TestNoRetryOnWrite(t *testing.T) {
+	var calls int32
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		writeResponse(w, 500, nil, "busy", types.ErrStorage.Code)
+	}, ClientConfig{Retries: 3})
+	defer done()
+
+	ctx := context.Background()
+	for name, write := range map[string]func() error{
+		"create token": func() error {
+			_, err := cli.CreateApiToken(ctx, types.ApiTokenCreateInput{})
+			return err
+		},
+		"register": func() error {
+			_, err := cli.RegisterDevice(ctx, types.DeviceRegisterInput{})
+			return err
+		},
+		"alertmgr address": func() error {
+			_, err := cli.SetAlertMgrAddress(ctx, types.DevopsAlertMgrAddressPostInput{})
+			return err
+		},
+		"decommission": func() error {
+			_, err := cli.DecommissionDevice(ctx, types.DeviceDecommissionInput{})
+			return err
+		},
+		"transfer": func() error {
+			_, err := cli.TransferDevice(ctx, types.DeviceTransferInput{})
+			return err
+		},
+	} {
+		atomic.StoreInt32(&calls, 0)
+		err := write()
+		if !IsCode(err, types.ErrStorage) || atomic.LoadInt32(&calls) != 1 {
+			t.Fatalf("%v: unexpected error %v after %v calls", name, err, calls)
+		}
+	}
+}
+
+func TestContextCancel(t *testing.T) {
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		writeResponse(w, 503, nil, "busy", types.ErrUpstream.Code)
+	}, ClientConfig{Retries: 100, Backoff: time.Hour})
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := cli.DevicesMetrics(ctx, types.MetricInput{})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expect %v, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatalf("call not cancelled in time")
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	block := make(chan struct{})
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		<-block
+	}, ClientConfig{Timeout: 50 * time.Millisecond})
+	defer done()
+	defer close(block)
+
+	err := cli.ReportDevice(context.Background(), types.DeviceReportInput{})
+	if err == nil {
+		t.Fatalf("expect timeout error")
+	}
+}
+
+func TestSignedReport(t *testing.T) {
+	secret := "secret"
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		b, _ := ioutil.ReadAll(req.Body)
+		timestamp, err := strconv.ParseInt(req.Header.Get(DeviceTimestampHeader), 10, 64)
+		if err != nil {
+			t.Errorf("invalid timestamp: %v", err)
+		}
+		expected := SignDeviceRequest(secret, req.Method, req.URL.Path,
+			timestamp, req.Header.Get(DeviceNonceHeader), b)
+		if req.Header.Get(DeviceSignatureHeader) != expected {
+			t.Errorf("signature mismatch")
+		}
+		writeResponse(w, 200, nil, "", 0)
+	}, ClientConfig{DeviceSecret: secret})
+	defer done()
+
+	err := cli.ReportDevice(context.Background(), types.DeviceReportInput{Id: uuid.New()})
+	if err != nil {
+		t.Fatalf("cannot report: %v", err)
+	}
+}
+
+func TestAlertMgrAddress(t *testing.T) {
+	id := uuid.New()
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		if req.Method != "GET" || query.Get("id") != id.String() || query.Get("parent_spec") != "a,b" {
+			t.Errorf("unexpected request %v %v", req.Method, req.URL)
+		}
+		writeResponse(w, 200, types.DevopsAlertMgrAddressGetOutput{Address: "1.1.1.1:9093"}, "", 0)
+	}, ClientConfig{})
+	defer done()
+
+	output, err := cli.AlertMgrAddress(context.Background(), types.DevopsAlertMgrAddressGetInput{
+		Id:          id,
+		ParentSpecs: []string{"a", "b"},
+	})
+	if err != nil {
+		t.Fatalf("cannot query address: %v", err)
+	}
+	if output.Address != "1.1.1.1:9093" {
+		t.Fatalf("unexpected output %v", output)
+	}
+}
+
+func TestMaintainBatchFailed(t *testing.T) {
+	id := uuid.New()
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		writeResponse(w, types.ErrBatchFailed.HttpStatus, types.MaintainingOutput{
+			Results: []types.DeviceMaintainResult{{DeviceID: id, Error: "not found"}},
+		}, "rolled back", types.ErrBatchFailed.Code)
+	}, ClientConfig{})
+	defer done()
+
+	output, err := cli.MaintainDevices(context.Background(), types.MaintainingInput{DeviceIDs: []uuid.UUID{id}})
+	if !IsCode(err, types.ErrBatchFailed) {
+		t.Fatalf("expect %v, got %v", types.ErrBatchFailed.Reason, err)
+	}
+	if output == nil || len(output.Results) != 1 || output.Results[0].DeviceID != id {
+		t.Fatalf("unexpected output %v", output)
+	}
+}
+
+func TestInvalidResponse(t *testing.T) {
+	cli, done := newTestClient(t, func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(502)
+		w.Write([]byte("bad gateway"))
+	}, ClientConfig{})
+	defer done()
+
+	_, err := cli.DeviceTopology(context.Background(), types.DeviceTopologyInput{})
+	if err == nil {
+		t.Fatalf("expect error")
+	}
+	if _, ok := AsError(err); ok {
+		t.Fatalf("unexpected typed error %v", err)
+	}
+}
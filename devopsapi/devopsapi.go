@@ -1,57 +1,44 @@
 package devopsapi
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"time"
+
 	log "github.com/EntropyPool/entropy-logger"
 	types "github.com/NpoolDevOps/fbc-devops-service/types"
 	etcdcli "github.com/NpoolDevOps/fbc-license-service/etcdcli"
-	"github.com/NpoolRD/http-daemon"
-	"golang.org/x/xerrors"
 )
 
 const devopsDomain = "devops.npool.top"
 
-func MyDevicesByUsername(input types.MyDevicesByUsernameInput, useDomain bool) (*types.MyDevicesOutput, error) {
-	var host string
-	var err error
-	var scheme string
-
+func DefaultBaseURL(useDomain bool) (string, error) {
 	if useDomain {
-		scheme = "https"
-		host = devopsDomain
-	} else {
-		host, err = etcdcli.GetHostByDomain(devopsDomain)
-		if err != nil {
-			return nil, err
-		}
-		scheme = "http"
+		return fmt.Sprintf("https://%v", devopsDomain), nil
 	}
 
-	log.Infof(log.Fields{}, "req to %v://%v%v", scheme, host, types.MyDevicesByUsernameAPI)
+	host, err := etcdcli.GetHostByDomain(devopsDomain)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("http://%v", host), nil
+}
 
-	resp, err := httpdaemon.Cli().SetTimeout(30*time.Minute).R().
-		SetHeader("Content-Type", "application/json").
-		SetBody(input).
-		Post(fmt.Sprintf("%v://%v%v", scheme, host, types.MyDevicesByUsernameAPI))
+func MyDevicesByUsername(input types.MyDevicesByUsernameInput, useDomain bool) (*types.MyDevicesOutput, error) {
+	baseURL, err := DefaultBaseURL(useDomain)
 	if err != nil {
-		log.Errorf(log.Fields{}, "heartbeat error: %v", err)
 		return nil, err
 	}
 
-	if resp.StatusCode() != 200 {
-		return nil, xerrors.Errorf("NON-200 return")
-	}
+	log.Infof(log.Fields{}, "req to %v%v", baseURL, types.MyDevicesByUsernameAPI)
 
-	apiResp, err := httpdaemon.ParseResponse(resp)
+	cli, err := NewClient(ClientConfig{
+		BaseURL: baseURL,
+		Timeout: 30 * time.Minute,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	output := types.MyDevicesOutput{}
-	b, _ := json.Marshal(apiResp.Body)
-	err = json.Unmarshal(b, &output)
-
-	return &output, err
+	return cli.MyDevicesByUsername(context.Background(), input)
 }
@@ -0,0 +1,80 @@
+package devopsapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"golang.org/x/xerrors"
+)
+
+// Error is returned for every response carrying a non-zero code, use IsCode to
+// match it against the catalog in types.
+type Error struct {
+	types.ErrorCode
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%v: %v (%v)", e.Reason, e.Msg, e.Code)
+}
+
+func (e *Error) Is(target error) bool {
+	switch t := target.(type) {
+	case *Error:
+		return t.Code == e.Code
+	}
+	return false
+}
+
+// AsError extracts the typed error of a devops api call, if any.
+func AsError(err error) (*Error, bool) {
+	var apiErr *Error
+	ok := errors.As(err, &apiErr)
+	return apiErr, ok
+}
+
+// IsCode reports whether err is a devops api error with the given catalog code.
+func IsCode(err error, code types.ErrorCode) bool {
+	apiErr, ok := AsError(err)
+	return ok && apiErr.Code == code.Code
+}
+
+type apiResp struct {
+	Code *int            `json:"code"`
+	Msg  string          `json:"msg"`
+	Body json.RawMessage `json:"body"`
+}
+
+// parseResponse decodes the body into out even when the call failed, some
+// failures like a rolled back batch still carry per item results.
+func parseResponse(statusCode int, b []byte, out interface{}) error {
+	resp := apiResp{}
+	err := json.Unmarshal(b, &resp)
+	if err != nil || resp.Code == nil {
+		if statusCode != 200 {
+			return xerrors.Errorf("NON-200 return: %v", statusCode)
+		}
+		return xerrors.Errorf("invalid api response")
+	}
+
+	if out != nil && len(resp.Body) > 0 && string(resp.Body) != "null" {
+		err = json.Unmarshal(resp.Body, out)
+		if err != nil && *resp.Code == 0 {
+			return err
+		}
+	}
+
+	if *resp.Code != 0 {
+		errorCode := types.ErrorCodeOf(*resp.Code)
+		errorCode.Code = *resp.Code
+		errorCode.HttpStatus = statusCode
+		return &Error{
+			ErrorCode: errorCode,
+			Msg:       resp.Msg,
+		}
+	}
+
+	return nil
+}
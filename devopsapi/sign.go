@@ -0,0 +1,23 @@
+package devopsapi
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+const (
+	DeviceTimestampHeader = "X-Devops-Timestamp"
+	DeviceNonceHeader     = "X-Devops-Nonce"
+	DeviceSignatureHeader = "X-Devops-Signature"
+)
+
+// SignDeviceRequest computes the signature a device attaches to register and
+// report calls with the secret issued at its first registration.
+func SignDeviceRequest(secret string, method string, path string, timestamp int64, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%v\n%v\n%v\n%v\n", method, path, timestamp, nonce)))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
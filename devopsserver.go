@@ -2,48 +2,80 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"strings"
 	"time"
 
 	log "github.com/EntropyPool/entropy-logger"
-	authapi "github.com/NpoolDevOps/fbc-auth-service/authapi"
 	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
 	"github.com/NpoolDevOps/fbc-devops-service/gateway"
 	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
 	devopsredis "github.com/NpoolDevOps/fbc-devops-service/redis"
 	types "github.com/NpoolDevOps/fbc-devops-service/types"
-	licapi "github.com/NpoolDevOps/fbc-license-service/licenseapi"
-	lictypes "github.com/NpoolDevOps/fbc-license-service/types"
 	httpdaemon "github.com/NpoolRD/http-daemon"
 	"github.com/google/uuid"
+	"golang.org/x/xerrors"
 )
 
 type DevopsConfig struct {
-	RedisCfg devopsredis.RedisConfig `json:"redis"`
-	MysqlCfg devopsmysql.MysqlConfig `json:"mysql"`
-	Port     int                     `json:"port"`
+	RedisCfg            devopsredis.RedisConfig  `json:"redis"`
+	MysqlCfg            devopsmysql.MysqlConfig  `json:"mysql"`
+	PrometheusCfg       gateway.PrometheusConfig `json:"prometheus"`
+	Port                int                      `json:"port"`
+	OfflineInterval     int                      `json:"offline_interval"`
+	ReportRetentionDays int                      `json:"report_retention_days"`
+	AuthAppId           string                   `json:"auth_app_id"`
+	AuthProvider        string                   `json:"auth_provider"`
+	AuthUsersFile       string                   `json:"auth_users_file"`
+	AuthServiceUsername string                   `json:"auth_service_username"`
+	AuthServicePassword string                   `json:"auth_service_password"`
+	DeviceIdResolver    string                   `json:"device_id_resolver"`
+	DeviceIdNamespace   string                   `json:"device_id_namespace"`
+	AllowUnsignedDevice bool                     `json:"allow_unsigned_device"`
+	AllowOwnerMaintain  bool                     `json:"allow_owner_maintain"`
+	AutoMigrate         bool                     `json:"auto_migrate"`
+	TrustedProxies      []string                 `json:"trusted_proxies"`
 }
 
 type DevopsServer struct {
-	config      DevopsConfig
-	authText    string
-	redisClient *devopsredis.RedisCli
-	mysqlClient *devopsmysql.MysqlCli
+	config           DevopsConfig
+	authText         string
+	runtimeCache     RuntimeCache
+	deviceStore      DeviceStore
+	prometheusClient *gateway.PrometheusCli
+	alertMgrClient   *gateway.AlertMgrCli
+	authenticator    Authenticator
+	deviceIdResolver DeviceIdResolver
+	trustedProxies   []*net.IPNet
 }
 
-func NewDevopsServer(configFile string) *DevopsServer {
+func readDevopsConfig(configFile string) (DevopsConfig, error) {
+	// Devices deployed before request signing keep reporting unsigned until
+	// an operator rotates their secrets, turn this off once they all sign.
+	config := DevopsConfig{
+		AllowUnsignedDevice: true,
+	}
+
 	buf, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		log.Errorf(log.Fields{}, "cannot read file %v: %v", configFile, err)
-		return nil
+		return config, xerrors.Errorf("cannot read file %v: %v", configFile, err)
 	}
 
-	config := DevopsConfig{}
 	err = json.Unmarshal(buf, &config)
 	if err != nil {
-		log.Errorf(log.Fields{}, "cannot parse file %v: %v", configFile, err)
+		return config, xerrors.Errorf("cannot parse file %v: %v", configFile, err)
+	}
+
+	return config, nil
+}
+
+func NewDevopsServer(configFile string) *DevopsServer {
+	config, err := readDevopsConfig(configFile)
+	if err != nil {
+		log.Errorf(log.Fields{}, "%v", err)
 		return nil
 	}
 
@@ -61,90 +93,215 @@ func NewDevopsServer(configFile string) *DevopsServer {
 		return nil
 	}
 
-	server := &DevopsServer{
-		config:      config,
-		authText:    types.DevopsAuthText,
-		redisClient: redisCli,
-		mysqlClient: mysqlCli,
+	if config.AutoMigrate {
+		err = mysqlCli.MigrateTo(devopsmysql.LatestSchemaVersion())
+		if err != nil {
+			log.Errorf(log.Fields{}, "cannot migrate database: %v", err)
+			return nil
+		}
+	} else {
+		version, err := mysqlCli.SchemaVersion()
+		if err != nil {
+			log.Errorf(log.Fields{}, "cannot query schema version: %v", err)
+		} else if version < devopsmysql.LatestSchemaVersion() {
+			log.Errorf(log.Fields{}, "database schema %v is behind %v, run migrate up",
+				version, devopsmysql.LatestSchemaVersion())
+		}
+	}
+
+	log.Infof(log.Fields{}, "create prometheus cli: %v", config.PrometheusCfg.Url)
+	prometheusCli := gateway.NewPrometheusCli(config.PrometheusCfg)
+	if prometheusCli == nil {
+		log.Errorf(log.Fields{}, "cannot create prometheus client %v", config.PrometheusCfg.Url)
+		return nil
+	}
+
+	log.Infof(log.Fields{}, "create authenticator: %v", config.AuthProvider)
+	authenticator, err := newAuthenticator(config)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot create authenticator: %v", err)
+		return nil
+	}
+
+	log.Infof(log.Fields{}, "create device id resolver: %v", config.DeviceIdResolver)
+	deviceIdResolver, err := newDeviceIdResolver(config)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot create device id resolver: %v", err)
+		return nil
 	}
 
+	server := newDevopsServer(config, mysqlCli, redisCli, prometheusCli, authenticator, deviceIdResolver)
 	log.Infof(log.Fields{}, "successful to create devops server")
 
 	return server
 }
 
-func (s *DevopsServer) Run() error {
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.DeviceRegisterAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.DeviceRegisterRequest(w, req)
-		},
-	})
+func newDevopsServer(config DevopsConfig, deviceStore DeviceStore, runtimeCache RuntimeCache, prometheusCli *gateway.PrometheusCli, authenticator Authenticator, deviceIdResolver DeviceIdResolver) *DevopsServer {
+	return &DevopsServer{
+		config:           config,
+		authText:         types.DevopsAuthText,
+		runtimeCache:     runtimeCache,
+		deviceStore:      deviceStore,
+		prometheusClient: prometheusCli,
+		alertMgrClient:   gateway.NewAlertMgrCli(30 * time.Second),
+		authenticator:    authenticator,
+		deviceIdResolver: deviceIdResolver,
+		trustedProxies:   parseTrustedProxies(config.TrustedProxies),
+	}
+}
 
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.DeviceReportAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.DeviceReportRequest(w, req)
+func (s *DevopsServer) routers() []httpdaemon.HttpRouter {
+	return []httpdaemon.HttpRouter{
+		{
+			Location: types.DeviceRegisterAPI,
+			Method:   "POST",
+			Handler:  s.DeviceRegisterRequest,
 		},
-	})
-
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.DeviceMaintainAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.DeviceMaintainRequest(w, req)
+		{
+			Location: types.DeviceReportAPI,
+			Method:   "POST",
+			Handler:  s.DeviceReportRequest,
 		},
-	})
-
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.MyDevicesAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.MyDevicesByAuthRequest(w, req)
+		{
+			Location: types.DeviceMaintainAPI,
+			Method:   "POST",
+			Handler:  s.DeviceMaintainRequest,
 		},
-	})
-
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.MyDevicesByAuthAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.MyDevicesByAuthRequest(w, req)
+		{
+			Location: types.MyDevicesAPI,
+			Method:   "POST",
+			Handler:  s.MyDevicesByAuthRequest,
 		},
-	})
-
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.MyDevicesByUsernameAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.MyDevicesByUsernameRequest(w, req)
+		{
+			Location: types.MyDevicesByAuthAPI,
+			Method:   "POST",
+			Handler:  s.MyDevicesByAuthRequest,
 		},
-	})
-
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.DevopsAlertMgrAddressAPI,
-		Method:   "GET",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.DevopsAlertMgrAddressGetRequest(w, req)
+		{
+			Location: types.MyDevicesByUsernameAPI,
+			Method:   "POST",
+			Handler:  s.MyDevicesByUsernameRequest,
 		},
-	})
-
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.DevopsAlertMgrAddressAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.DevopsAlertMgrAddressPostRequest(w, req)
+		{
+			Location: types.DevopsAlertMgrAddressAPI,
+			Method:   "GET",
+			Handler:  s.DevopsAlertMgrAddressGetRequest,
 		},
-	})
-
-	httpdaemon.RegisterRouter(httpdaemon.HttpRouter{
-		Location: types.MyDevicesMetricsAPI,
-		Method:   "POST",
-		Handler: func(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-			return s.DevicesMetricsRequest(w, req)
+		{
+			Location: types.DevopsAlertMgrAddressAPI,
+			Method:   "POST",
+			Handler:  s.DevopsAlertMgrAddressPostRequest,
 		},
-	})
+		{
+			Location: types.MyDevicesMetricsAPI,
+			Method:   "POST",
+			Handler:  s.DevicesMetricsRequest,
+		},
+		{
+			Location: types.DeviceDriftsAPI,
+			Method:   "POST",
+			Handler:  s.DeviceDriftsRequest,
+		},
+		{
+			Location: types.DeviceDecommissionAPI,
+			Method:   "POST",
+			Handler:  s.DeviceDecommissionRequest,
+		},
+		{
+			Location: types.DeviceTransferAPI,
+			Method:   "POST",
+			Handler:  s.DeviceTransferRequest,
+		},
+		{
+			Location: types.DeviceOwnershipsAPI,
+			Method:   "POST",
+			Handler:  s.DeviceOwnershipsRequest,
+		},
+		{
+			Location: types.DeviceRolesAPI,
+			Method:   "POST",
+			Handler:  s.DeviceRolesRequest,
+		},
+		{
+			Location: types.DeviceRoleCreateAPI,
+			Method:   "POST",
+			Handler:  s.DeviceRoleCreateRequest,
+		},
+		{
+			Location: types.DeviceRoleUpdateAPI,
+			Method:   "POST",
+			Handler:  s.DeviceRoleUpdateRequest,
+		},
+		{
+			Location: types.DeviceRoleDeleteAPI,
+			Method:   "POST",
+			Handler:  s.DeviceRoleDeleteRequest,
+		},
+		{
+			Location: types.DeviceTopologyAPI,
+			Method:   "POST",
+			Handler:  s.DeviceTopologyRequest,
+		},
+		{
+			Location: types.DeviceDetailAPI,
+			Method:   "GET",
+			Handler:  s.DeviceDetailGetRequest,
+		},
+		{
+			Location: types.DeviceDetailAPI,
+			Method:   "POST",
+			Handler:  s.DeviceDetailPostRequest,
+		},
+		{
+			Location: types.ApiTokenCreateAPI,
+			Method:   "POST",
+			Handler:  s.ApiTokenCreateRequest,
+		},
+		{
+			Location: types.ApiTokensAPI,
+			Method:   "POST",
+			Handler:  s.ApiTokensRequest,
+		},
+		{
+			Location: types.ApiTokenRevokeAPI,
+			Method:   "POST",
+			Handler:  s.ApiTokenRevokeRequest,
+		},
+		{
+			Location: types.AuditLogsAPI,
+			Method:   "POST",
+			Handler:  s.AuditLogsRequest,
+		},
+		{
+			Location: types.MaintenanceCreateAPI,
+			Method:   "POST",
+			Handler:  s.MaintenanceCreateRequest,
+		},
+		{
+			Location: types.MaintenanceCancelAPI,
+			Method:   "POST",
+			Handler:  s.MaintenanceCancelRequest,
+		},
+		{
+			Location: types.MaintenancesAPI,
+			Method:   "POST",
+			Handler:  s.MaintenancesRequest,
+		},
+		{
+			Location: types.DeviceSecretRotateAPI,
+			Method:   "POST",
+			Handler:  s.DeviceSecretRotateRequest,
+		},
+	}
+}
+
+func (s *DevopsServer) Run() error {
+	for _, router := range s.routers() {
+		httpdaemon.RegisterRouter(router)
+	}
+
+	go s.offlineWatcher()
+	go s.maintenanceScheduler()
 
 	log.Infof(log.Fields{}, "start http daemon at %v", s.config.Port)
 	httpdaemon.Run(s.config.Port)
@@ -154,37 +311,60 @@ func (s *DevopsServer) Run() error {
 func (s *DevopsServer) DeviceRegisterRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return nil, err.Error(), -1
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
 	input := types.DeviceRegisterInput{}
 	err = json.Unmarshal(b, &input)
 	if err != nil {
-		return nil, err.Error(), -2
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
-	clientInfo, err := licapi.ClientInfoBySpec(lictypes.ClientInfoBySpecInput{
-		Spec: input.Spec,
-	})
+	if input.Spec == "" {
+		return fail(w, types.ErrInvalidParam, "spec is must")
+	}
+
+	deviceId, err := s.deviceIdResolver.DeviceId(input.Spec)
 	if err != nil {
-		return nil, err.Error(), -3
+		return fail(w, types.ErrUpstream, err.Error())
+	}
+
+	oldConfig, err := s.deviceStore.QueryDeviceConfig(deviceId)
+	registered := err == nil
+
+	issueSecret, err := s.authorizeDeviceRegister(req, deviceId, registered, b)
+	if err != nil {
+		return fail(w, types.ErrInvalidSignature, err.Error())
+	}
+
+	if registered && oldConfig.Decommissioned {
+		return fail(w, types.ErrDeviceDecommissioned, "device is decommissioned")
 	}
 
 	if input.Role == "" {
-		return nil, "role is must", -4
+		return fail(w, types.ErrInvalidParam, "role is must")
+	}
+
+	valid, err := s.deviceStore.ValidateRole(input.Role)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
 	}
 
-	valid, err := s.mysqlClient.ValidateRole(input.Role)
+	if !valid {
+		return fail(w, types.ErrInvalidParam, "role is not valid")
+	}
+
+	valid, err = s.deviceStore.ValidateSubRole(input.Role, input.SubRole)
 	if err != nil {
-		return nil, err.Error(), -5
+		return fail(w, types.ErrStorage, err.Error())
 	}
 
 	if !valid {
-		return nil, "role is not valid", -6
+		return fail(w, types.ErrInvalidParam, "sub role is not valid")
 	}
 
 	config := devopsmysql.DeviceConfig{}
-	config.Id = clientInfo.Id
+	config.Id = deviceId
 	config.Spec = input.Spec
 	config.ParentSpec = input.ParentSpec
 	config.Role = input.Role
@@ -208,19 +388,32 @@ func (s *DevopsServer) DeviceRegisterRequest(w http.ResponseWriter, req *http.Re
 	config.CreateTime = time.Now()
 	config.ModifyTime = time.Now()
 
-	input.Id = clientInfo.Id
-	err = s.redisClient.InsertKeyInfo("device", input.Id, input, 2*time.Hour)
+	input.Id = deviceId
+	err = s.runtimeCache.InsertKeyInfo("device", input.Id, input, 2*time.Hour)
 	if err != nil {
-		return nil, err.Error(), -7
+		return fail(w, types.ErrCache, err.Error())
 	}
 
-	err = s.mysqlClient.InsertDeviceConfig(config)
+	output := types.DeviceRegisterOutput{}
+	output.Id = deviceId
+
+	before := s.deviceSnapshot(config.Id)
+
+	err = s.deviceStore.InsertDeviceConfig(config)
 	if err != nil {
-		return nil, err.Error(), -8
+		return fail(w, types.ErrConflict, err.Error())
 	}
 
-	output := types.DeviceRegisterOutput{}
-	output.Id = clientInfo.Id
+	actor := fmt.Sprintf("device:%v", input.Spec)
+	s.audit(req, actor, types.AuditActionRegister, config.Id, before, s.deviceSnapshot(config.Id))
+
+	if issueSecret {
+		output.Secret, err = s.issueDeviceSecret(deviceId)
+		if err != nil {
+			return fail(w, types.ErrStorage, err.Error())
+		}
+		s.audit(req, actor, types.AuditActionSecretIssue, config.Id, nil, s.secretSnapshot(config.Id))
+	}
 
 	return output, "", 0
 }
@@ -228,18 +421,39 @@ func (s *DevopsServer) DeviceRegisterRequest(w http.ResponseWriter, req *http.Re
 func (s *DevopsServer) DeviceReportRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return nil, err.Error(), -1
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
 	input := types.DeviceReportInput{}
 	err = json.Unmarshal(b, &input)
 	if err != nil {
-		return nil, err.Error(), -2
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	err = s.authorizeDeviceReport(req, input.Id, b)
+	if err != nil {
+		return fail(w, types.ErrInvalidSignature, err.Error())
 	}
 
-	device, err := s.redisClient.QueryDevice(input.Id)
+	config, err := s.deviceStore.QueryDeviceConfig(input.Id)
 	if err != nil {
-		return nil, err.Error(), -3
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if config.Decommissioned {
+		return fail(w, types.ErrDeviceDecommissioned, "device is decommissioned")
+	}
+
+	device, err := s.runtimeCache.QueryDevice(input.Id)
+	if err != nil {
+		device = &types.DeviceConfig{
+			Id:         config.Id,
+			Spec:       config.Spec,
+			ParentSpec: config.ParentSpec,
+			Role:       config.Role,
+			SubRole:    config.SubRole,
+			OsSpec:     config.OsSpec,
+		}
 	}
 
 	device.NvmeCount = input.NvmeCount
@@ -248,210 +462,664 @@ func (s *DevopsServer) DeviceReportRequest(w http.ResponseWriter, req *http.Requ
 	device.MemorySize = input.MemorySize
 	device.HddCount = input.HddCount
 	device.LocalAddr = input.LocalAddr
-	device.PublicAddr = input.LocalAddr
+	device.PublicAddr = input.PublicAddr
 
-	err = s.redisClient.InsertKeyInfo("device", input.Id, input, 2*time.Hour)
+	err = s.runtimeCache.InsertKeyInfo("device", input.Id, device, 2*time.Hour)
+	if err != nil {
+		return fail(w, types.ErrCache, err.Error())
+	}
+
+	err = s.deviceStore.InsertDeviceReport(devopsmysql.DeviceReport{
+		DeviceId:    input.Id,
+		NvmeCount:   input.NvmeCount,
+		GpuCount:    input.GpuCount,
+		MemoryCount: input.MemoryCount,
+		MemorySize:  input.MemorySize,
+		HddCount:    input.HddCount,
+		LocalAddr:   input.LocalAddr,
+		PublicAddr:  input.PublicAddr,
+	})
 	if err != nil {
-		return nil, err.Error(), -3
+		return fail(w, types.ErrStorage, err.Error())
 	}
 
+	s.detectDeviceDrift(config, input)
+	s.heartbeat(config.Id, config.Offline)
+
 	return nil, "", 0
 }
 
+func (s *DevopsServer) detectDeviceDrift(config *devopsmysql.DeviceConfig, input types.DeviceReportInput) {
+	drifts := []devopsmysql.DeviceDrift{
+		{Item: devopsmysql.DriftItemNvme, Expected: config.NvmeCount, Actual: input.NvmeCount},
+		{Item: devopsmysql.DriftItemGpu, Expected: config.GpuCount, Actual: input.GpuCount},
+		{Item: devopsmysql.DriftItemMemoryCount, Expected: config.MemoryCount, Actual: input.MemoryCount},
+		{Item: devopsmysql.DriftItemHdd, Expected: config.HddCount, Actual: input.HddCount},
+	}
+
+	for _, drift := range drifts {
+		drift.DeviceId = config.Id
+		inserted, err := s.deviceStore.InsertDeviceDrift(drift)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to record %v drift of %v: %v", drift.Item, config.Id, err)
+			continue
+		}
+		if inserted {
+			log.Infof(log.Fields{}, "device %v %v drift: expected %v, actual %v",
+				config.Id, drift.Item, drift.Expected, drift.Actual)
+		}
+	}
+}
+
+func (s *DevopsServer) DeviceDriftsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceDriftsInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	var deviceIds []uuid.UUID
+
+	if !user.SuperUser {
+		infos, _, err := s.deviceStore.QueryDeviceConfigsByFilter(visibleDeviceFilter(user, devopsmysql.DeviceFilter{
+			IncludeDecommissioned: true,
+		}))
+		if err != nil {
+			return fail(w, types.ErrStorage, err.Error())
+		}
+		deviceIds = []uuid.UUID{}
+		for _, info := range infos {
+			if input.DeviceID == uuid.Nil || input.DeviceID == info.Id {
+				deviceIds = append(deviceIds, info.Id)
+			}
+		}
+		if len(deviceIds) == 0 {
+			return fail(w, types.ErrPermissionDenied, "permission denied")
+		}
+	} else if input.DeviceID != uuid.Nil {
+		deviceIds = []uuid.UUID{input.DeviceID}
+	}
+
+	drifts, err := s.deviceStore.QueryDeviceDrifts(deviceIds)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	output := types.DeviceDriftsOutput{
+		Drifts: []types.DeviceDrift{},
+	}
+	for _, drift := range drifts {
+		output.Drifts = append(output.Drifts, types.DeviceDrift{
+			Id:         drift.Id,
+			DeviceID:   drift.DeviceId,
+			Item:       drift.Item,
+			Expected:   drift.Expected,
+			Actual:     drift.Actual,
+			Recovered:  drift.Expected == drift.Actual,
+			CreateTime: drift.CreateTime,
+		})
+	}
+
+	return output, "", 0
+}
+
 func (s *DevopsServer) DeviceMaintainRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return nil, err.Error(), -1
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
 	input := types.MaintainingInput{}
 	err = json.Unmarshal(b, &input)
 	if err != nil {
-		return nil, err.Error(), -2
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
-	if input.AuthCode == "" {
-		return nil, "auth code is must", -3
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
 	}
 
-	user, err := authapi.UserInfo(authtypes.UserInfoInput{
-		AuthCode: input.AuthCode,
-	})
+	// Maintaining devices skip offline detection and accept config changes
+	// at registration, so only super users toggle it unless the site lets
+	// owners, current users and managers maintain their own devices.
+	if !user.SuperUser && !s.config.AllowOwnerMaintain {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	ids, err := s.maintainTargets(user, input)
 	if err != nil {
-		return nil, err.Error(), -5
+		return fail(w, types.ErrInvalidParam, err.Error())
 	}
 
-	if !user.SuperUser {
-		return nil, "permission denied", -6
+	if len(ids) == 0 {
+		return fail(w, types.ErrInvalidParam, "no device selected")
+	}
+
+	for _, id := range ids {
+		config, err := s.deviceStore.QueryDeviceConfig(id)
+		if err != nil {
+			continue
+		}
+		if !canAccessDevice(user, config) {
+			return fail(w, types.ErrPermissionDenied, "permission denied")
+		}
+		if config.Decommissioned {
+			return fail(w, types.ErrDeviceDecommissioned, fmt.Sprintf("device %v is decommissioned", id))
+		}
+	}
+
+	befores := map[uuid.UUID]interface{}{}
+	for _, id := range ids {
+		befores[id] = s.deviceSnapshot(id)
+	}
+
+	failures, err := s.deviceStore.SetDevicesMaintaining(ids, input.Maintaining)
+
+	output := types.MaintainingOutput{
+		Results: []types.DeviceMaintainResult{},
+	}
+	for _, id := range ids {
+		result := types.DeviceMaintainResult{
+			DeviceID: id,
+			Success:  err == nil,
+		}
+		if failure, ok := failures[id]; ok {
+			result.Error = failure.Error()
+		} else if err != nil {
+			result.Error = "rolled back"
+		}
+		output.Results = append(output.Results, result)
 	}
 
-	err = s.mysqlClient.SetDeviceMaintaining(input.DeviceID, input.Maintaining)
 	if err != nil {
-		return nil, err.Error(), -7
+		return failWith(w, output, types.ErrBatchFailed, err.Error())
 	}
 
-	return nil, "", 0
+	for _, id := range ids {
+		s.audit(req, user.Username, types.AuditActionMaintain,
+			id, befores[id], s.deviceSnapshot(id))
+	}
+
+	return output, "", 0
+}
+
+func (s *DevopsServer) maintainTargets(user *authtypes.UserInfoOutput, input types.MaintainingInput) ([]uuid.UUID, error) {
+	ids := []uuid.UUID{}
+	selected := map[uuid.UUID]struct{}{}
+
+	add := func(id uuid.UUID) {
+		if _, ok := selected[id]; ok {
+			return
+		}
+		selected[id] = struct{}{}
+		ids = append(ids, id)
+	}
+
+	if input.DeviceID != uuid.Nil {
+		add(input.DeviceID)
+	}
+	for _, id := range input.DeviceIDs {
+		add(id)
+	}
+
+	if input.Selector != nil {
+		if input.Selector.Role == "" && input.Selector.SubRole == "" && input.Selector.ParentSpec == "" {
+			return nil, xerrors.Errorf("empty selector")
+		}
+
+		infos, _, err := s.deviceStore.QueryDeviceConfigsByFilter(visibleDeviceFilter(user, devopsmysql.DeviceFilter{
+			Role:       input.Selector.Role,
+			SubRole:    input.Selector.SubRole,
+			ParentSpec: input.Selector.ParentSpec,
+		}))
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range infos {
+			add(info.Id)
+		}
+	}
+
+	return ids, nil
 }
 
 func (s *DevopsServer) MyDevicesByAuthRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return nil, err.Error(), -1
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
 	input := types.MyDevicesByAuthInput{}
 	err = json.Unmarshal(b, &input)
 	if err != nil {
-		return nil, err.Error(), -2
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
-	if input.AuthCode == "" {
-		return nil, "auth code is must", -3
-	}
-
-	user, err := authapi.UserInfo(authtypes.UserInfoInput{
-		AuthCode: input.AuthCode,
-	})
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
 	if err != nil {
-		return nil, err.Error(), -4
+		return fail(w, types.ErrUnauthenticated, err.Error())
 	}
 
-	return s.myDevicesByUserInfo(user)
+	return s.myDevicesByUserInfo(w, user, input.DeviceListFilter)
 }
 
 func (s *DevopsServer) MyDevicesByUsernameRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return nil, err.Error(), -1
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
 	input := types.MyDevicesByUsernameInput{}
 	err = json.Unmarshal(b, &input)
 	if err != nil {
-		return nil, err.Error(), -2
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	if bearerToken(req) != "" {
+		user, err := s.authenticate(req, "", types.TokenScopeRead)
+		if err != nil {
+			return fail(w, types.ErrUnauthenticated, err.Error())
+		}
+		return s.myDevicesByUserInfo(w, user, input.DeviceListFilter)
 	}
 
 	if input.Username == "" {
-		return nil, "username is must", -3
+		return fail(w, types.ErrInvalidParam, "username is must")
 	}
 
 	if input.Password == "" {
-		return nil, "password is must", -4
+		return fail(w, types.ErrInvalidParam, "password is must")
 	}
 
-	output, err := authapi.Login(authtypes.UserLoginInput{
-		Username: input.Username,
-		Password: input.Password,
-		AppId:    uuid.MustParse("00000002-0002-0002-0002-000000000002"),
-	})
+	user, err := s.authenticator.Login(input.Username, input.Password)
 	if err != nil {
-		return nil, err.Error(), -5
+		return fail(w, types.ErrUnauthenticated, err.Error())
 	}
 
-	user, err := authapi.UserInfo(authtypes.UserInfoInput{
-		AuthCode: output.AuthCode,
-	})
-	if err != nil {
-		return nil, err.Error(), -6
+	return s.myDevicesByUserInfo(w, user, input.DeviceListFilter)
+}
+
+func deviceAttribute(info devopsmysql.DeviceConfig, device *types.DeviceConfig) types.DeviceAttribute {
+	oInfo := types.DeviceAttribute{}
+
+	oInfo.Id = info.Id
+	oInfo.Spec = info.Spec
+	oInfo.ParentSpec = strings.Split(info.ParentSpec, ",")
+	oInfo.Role = info.Role
+	oInfo.SubRole = info.SubRole
+	oInfo.Owner = info.Owner
+	oInfo.CurrentUser = info.CurrentUser
+	oInfo.Manager = info.Manager
+	oInfo.NvmeCount = info.NvmeCount
+	oInfo.NvmeDesc = strings.Split(info.NvmeDesc, ",")
+	oInfo.GpuCount = info.GpuCount
+	oInfo.GpuDesc = strings.Split(info.GpuDesc, ",")
+	oInfo.MemoryCount = info.MemoryCount
+	oInfo.MemorySize = info.MemorySize
+	oInfo.MemoryDesc = strings.Split(info.MemoryDesc, ",")
+	oInfo.CpuCount = info.CpuCount
+	oInfo.CpuDesc = strings.Split(info.CpuDesc, ",")
+	oInfo.HddCount = info.HddCount
+	oInfo.HddDesc = strings.Split(info.HddDesc, ",")
+	oInfo.OsSpec = info.OsSpec
+	oInfo.Maintaining = info.Maintaining
+	oInfo.Offline = info.Offline
+	oInfo.Decommissioned = info.Decommissioned
+
+	if device != nil {
+		oInfo.RuntimeNvmeCount = device.NvmeCount
+		oInfo.RuntimeGpuCount = device.GpuCount
+		oInfo.RuntimeMemoryCount = device.MemoryCount
+		oInfo.RuntimeMemorySize = device.MemorySize
+		oInfo.RuntimeHddCount = device.HddCount
+		oInfo.LocalAddr = device.LocalAddr
+		oInfo.PublicAddr = device.PublicAddr
 	}
 
-	return s.myDevicesByUserInfo(user)
+	return oInfo
 }
 
-func (s *DevopsServer) myDevicesByUserInfo(user *authtypes.UserInfoOutput) (interface{}, string, int) {
-	infos := []devopsmysql.DeviceConfig{}
-	var err error
+func (s *DevopsServer) myDevicesByUserInfo(w http.ResponseWriter, user *authtypes.UserInfoOutput, listFilter types.DeviceListFilter) (interface{}, string, int) {
+	if listFilter.Page < 0 || listFilter.Limit < 0 {
+		return fail(w, types.ErrInvalidParam, "invalid page or limit")
+	}
+
+	if !devopsmysql.ValidDeviceSortKey(listFilter.SortBy) {
+		return fail(w, types.ErrInvalidParam, fmt.Sprintf("invalid sort key %v", listFilter.SortBy))
+	}
 
-	if user.SuperUser {
-		infos, err = s.mysqlClient.QueryDeviceConfigs()
-	} else {
-		infos, err = s.mysqlClient.QueryDeviceConfigsByUser(user.Username)
+	filter := visibleDeviceFilter(user, devopsmysql.DeviceFilter{
+		Role:                  listFilter.Role,
+		SubRole:               listFilter.SubRole,
+		Owner:                 listFilter.Owner,
+		ParentSpec:            listFilter.ParentSpec,
+		OsSpec:                listFilter.OsSpec,
+		Maintaining:           listFilter.Maintaining,
+		Offline:               listFilter.Offline,
+		IncludeDecommissioned: listFilter.IncludeDecommissioned,
+		SortBy:                listFilter.SortBy,
+		SortDesc:              listFilter.SortDesc,
+		Limit:                 listFilter.Limit,
+	})
+
+	if listFilter.Limit > 0 && listFilter.Page > 1 {
+		filter.Offset = (listFilter.Page - 1) * listFilter.Limit
 	}
 
+	infos, total, err := s.deviceStore.QueryDeviceConfigsByFilter(filter)
 	if err != nil {
-		return nil, err.Error(), -6
+		return fail(w, types.ErrStorage, err.Error())
 	}
 
-	output := types.MyDevicesOutput{}
+	output := types.MyDevicesOutput{
+		Devices: []types.DeviceAttribute{},
+		Total:   total,
+		Page:    listFilter.Page,
+		Limit:   listFilter.Limit,
+	}
+
+	ids := []uuid.UUID{}
 	for _, info := range infos {
-		oInfo := types.DeviceAttribute{}
-
-		oInfo.Id = info.Id
-		oInfo.Spec = info.Spec
-		oInfo.ParentSpec = strings.Split(info.ParentSpec, ",")
-		oInfo.Role = info.Role
-		oInfo.SubRole = info.SubRole
-		oInfo.Owner = info.Owner
-		oInfo.CurrentUser = info.CurrentUser
-		oInfo.Manager = info.Manager
-		oInfo.NvmeCount = info.NvmeCount
-		oInfo.NvmeDesc = strings.Split(info.NvmeDesc, ",")
-		oInfo.GpuCount = info.GpuCount
-		oInfo.GpuDesc = strings.Split(info.GpuDesc, ",")
-		oInfo.MemoryCount = info.MemoryCount
-		oInfo.MemorySize = info.MemorySize
-		oInfo.MemoryDesc = strings.Split(info.MemoryDesc, ",")
-		oInfo.CpuCount = info.CpuCount
-		oInfo.CpuDesc = strings.Split(info.CpuDesc, ",")
-		oInfo.HddCount = info.HddCount
-		oInfo.HddDesc = strings.Split(info.HddDesc, ",")
-		oInfo.OsSpec = info.OsSpec
-		oInfo.Maintaining = info.Maintaining
-		oInfo.Offline = info.Offline
-
-		device, err := s.redisClient.QueryDevice(info.Id)
-		if err == nil {
-			oInfo.RuntimeNvmeCount = device.NvmeCount
-			oInfo.RuntimeGpuCount = device.GpuCount
-			oInfo.RuntimeMemoryCount = device.MemoryCount
-			oInfo.RuntimeMemorySize = device.MemorySize
-			oInfo.RuntimeHddCount = device.HddCount
-			oInfo.LocalAddr = device.LocalAddr
-			oInfo.PublicAddr = device.PublicAddr
-		}
+		ids = append(ids, info.Id)
+	}
 
-		output.Devices = append(output.Devices, oInfo)
+	devices, err := s.runtimeCache.QueryDevices(ids)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query runtime devices: %v", err)
+		devices = map[uuid.UUID]*types.DeviceConfig{}
+	}
+
+	for _, info := range infos {
+		output.Devices = append(output.Devices, deviceAttribute(info, devices[info.Id]))
 	}
 
 	return output, "", 0
 }
 
 func (s *DevopsServer) DevopsAlertMgrAddressPostRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-	return nil, "NOT IMPLEMENTED NOW", -1
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DevopsAlertMgrAddressPostInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	if input.Address == "" {
+		return fail(w, types.ErrInvalidParam, "address is must")
+	}
+
+	if input.SubRole != "" && input.Role == "" {
+		return fail(w, types.ErrInvalidParam, "role is must with sub role")
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	if !user.SuperUser {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	if input.Id == uuid.Nil {
+		input.Id = uuid.New()
+	}
+
+	var before interface{}
+	old, err := s.deviceStore.QueryAlertMgrAddress(input.Id)
+	if err == nil {
+		before = types.AlertMgrAddress{
+			Id:         old.Id,
+			Address:    old.Address,
+			Role:       old.Role,
+			SubRole:    old.SubRole,
+			ParentSpec: old.ParentSpec,
+		}
+	}
+
+	err = s.deviceStore.InsertAlertMgrAddress(devopsmysql.AlertMgrAddress{
+		Id:         input.Id,
+		Address:    input.Address,
+		Role:       input.Role,
+		SubRole:    input.SubRole,
+		ParentSpec: input.ParentSpec,
+	})
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	s.audit(req, user.Username, types.AuditActionAlertMgrUpdate, uuid.Nil, before, input.AlertMgrAddress)
+
+	_, err = s.refreshAlertMgrAddresses()
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to refresh alertmanager addresses: %v", err)
+	}
+
+	output := types.DevopsAlertMgrAddressPostOutput{}
+	output.Id = input.Id
+
+	return output, "", 0
 }
 
 func (s *DevopsServer) DevopsAlertMgrAddressGetRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
-	return nil, "NOT IMPLEMENTED NOW", -1
+	query := req.URL.Query()
+
+	addrs, err := s.alertMgrAddresses()
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	authCode := query.Get("auth_code")
+	if authCode != "" || bearerToken(req) != "" {
+		user, err := s.authenticate(req, authCode, types.TokenScopeRead)
+		if err != nil {
+			return fail(w, types.ErrUnauthenticated, err.Error())
+		}
+
+		if !user.SuperUser {
+			return fail(w, types.ErrPermissionDenied, "permission denied")
+		}
+
+		return types.DevopsAlertMgrAddressGetOutput{
+			Addresses: addrs,
+		}, "", 0
+	}
+
+	role := query.Get("role")
+	subRole := query.Get("sub_role")
+	parentSpecs := []string{}
+	if query.Get("parent_spec") != "" {
+		parentSpecs = strings.Split(query.Get("parent_spec"), ",")
+	}
+
+	if query.Get("id") != "" {
+		id, err := uuid.Parse(query.Get("id"))
+		if err != nil {
+			return fail(w, types.ErrInvalidParam, err.Error())
+		}
+
+		config, err := s.deviceStore.QueryDeviceConfig(id)
+		if err != nil {
+			return fail(w, types.ErrNotFound, err.Error())
+		}
+
+		role = config.Role
+		subRole = config.SubRole
+		if config.ParentSpec != "" {
+			parentSpecs = strings.Split(config.ParentSpec, ",")
+		}
+	}
+
+	addr := matchAlertMgrAddress(addrs, role, subRole, parentSpecs)
+	if addr == nil {
+		return fail(w, types.ErrNotFound, "no alertmanager address available")
+	}
+
+	return types.DevopsAlertMgrAddressGetOutput{
+		Address: addr.Address,
+	}, "", 0
+}
+
+func (s *DevopsServer) refreshAlertMgrAddresses() ([]types.AlertMgrAddress, error) {
+	infos, err := s.deviceStore.QueryAlertMgrAddresses()
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := []types.AlertMgrAddress{}
+	for _, info := range infos {
+		addrs = append(addrs, types.AlertMgrAddress{
+			Id:         info.Id,
+			Address:    info.Address,
+			Role:       info.Role,
+			SubRole:    info.SubRole,
+			ParentSpec: info.ParentSpec,
+		})
+	}
+
+	err = s.runtimeCache.InsertAlertMgrAddresses(addrs, 2*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	return addrs, nil
+}
+
+func (s *DevopsServer) alertMgrAddresses() ([]types.AlertMgrAddress, error) {
+	addrs, err := s.runtimeCache.QueryAlertMgrAddresses()
+	if err == nil {
+		return addrs, nil
+	}
+	return s.refreshAlertMgrAddresses()
+}
+
+// A parent spec match wins over role + sub role, which wins over role only;
+// an entry without any key acts as the default address.
+func matchAlertMgrAddress(addrs []types.AlertMgrAddress, role, subRole string, parentSpecs []string) *types.AlertMgrAddress {
+	var matched *types.AlertMgrAddress
+	bestScore := -1
+
+	for i, addr := range addrs {
+		score := -1
+
+		switch {
+		case addr.ParentSpec != "":
+			for _, spec := range parentSpecs {
+				if spec == addr.ParentSpec {
+					score = 3
+					break
+				}
+			}
+		case addr.Role != "" && addr.SubRole != "":
+			if addr.Role == role && addr.SubRole == subRole {
+				score = 2
+			}
+		case addr.Role != "":
+			if addr.Role == role {
+				score = 1
+			}
+		case addr.SubRole == "":
+			score = 0
+		}
+
+		if score > bestScore {
+			bestScore = score
+			matched = &addrs[i]
+		}
+	}
+
+	return matched
 }
 
 func (s *DevopsServer) DevicesMetricsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
 	b, err := ioutil.ReadAll(req.Body)
 	if err != nil {
-		return nil, err.Error(), -1
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 	input := types.MetricInput{}
 	err = json.Unmarshal(b, &input)
 	if err != nil {
-		return nil, err.Error(), -2
+		return fail(w, types.ErrInvalidRequest, err.Error())
 	}
 
-	if input.AuthCode == "" {
-		return nil, "auth code is must", -3
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
 	}
 
-	_, err = authapi.UserInfo(authtypes.UserInfoInput{
-		AuthCode: input.AuthCode,
-	})
+	output, err := s.prometheusClient.QueryMetrics(input)
 	if err != nil {
-		return nil, err.Error(), -4
+		return fail(w, types.ErrUpstream, err.Error())
 	}
 
-	output, err := gateway.GetMetrics(input.Metrics)
-	if err != nil {
-		return nil, err.Error(), -5
+	if !user.SuperUser {
+		output = filterMetricsByInstances(output, s.deviceAddressesByUser(user))
 	}
 
 	return types.MetricOutput{
 		MetricsValue: output,
 	}, "", 0
 }
+
+func (s *DevopsServer) deviceAddressesByUser(user *authtypes.UserInfoOutput) map[string]struct{} {
+	addrs := map[string]struct{}{}
+
+	infos, _, err := s.deviceStore.QueryDeviceConfigsByFilter(visibleDeviceFilter(user, devopsmysql.DeviceFilter{}))
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query devices of %v: %v", user.Username, err)
+		return addrs
+	}
+
+	ids := []uuid.UUID{}
+	for _, info := range infos {
+		ids = append(ids, info.Id)
+	}
+
+	devices, err := s.runtimeCache.QueryDevices(ids)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query runtime devices of %v: %v", user.Username, err)
+		return addrs
+	}
+
+	for _, device := range devices {
+		for _, addr := range []string{device.LocalAddr, device.PublicAddr} {
+			addr = strings.TrimSpace(strings.Split(addr, ":")[0])
+			if addr != "" {
+				addrs[addr] = struct{}{}
+			}
+		}
+	}
+
+	return addrs
+}
+
+func filterMetricsByInstances(metrics []types.Outresp, instances map[string]struct{}) []types.Outresp {
+	output := []types.Outresp{}
+	for _, metric := range metrics {
+		filtered := types.Outresp{
+			MetricName: metric.MetricName,
+		}
+		for _, m := range metric.Metric {
+			if _, ok := instances[m.Instance]; ok {
+				filtered.Metric = append(filtered.Metric, m)
+			}
+		}
+		output = append(output, filtered)
+	}
+	return output
+}
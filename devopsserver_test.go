@@ -0,0 +1,976 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	"github.com/NpoolDevOps/fbc-devops-service/authprovider"
+	devopsapi "github.com/NpoolDevOps/fbc-devops-service/devopsapi"
+	"github.com/NpoolDevOps/fbc-devops-service/idresolver"
+	"github.com/NpoolDevOps/fbc-devops-service/memstore"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+const (
+	superToken = "fbcdt_super"
+	userToken  = "fbcdt_user"
+)
+
+type testServer struct {
+	*DevopsServer
+	store *memstore.DeviceStore
+	cache *memstore.RuntimeCache
+	auth  *authprovider.Fake
+}
+
+func newTestServer(t *testing.T) *testServer {
+	store := memstore.NewDeviceStore()
+	cache := memstore.NewRuntimeCache()
+	auth := authprovider.NewFake()
+
+	server := newDevopsServer(DevopsConfig{}, store, cache, nil, auth, idresolver.NewLocal(uuid.Nil))
+
+	for token, username := range map[string]string{superToken: "admin", userToken: "alice"} {
+		auth.AddUser(username, "", token == superToken)
+		err := store.InsertApiToken(devopsmysql.ApiToken{
+			Id:         uuid.New(),
+			Name:       "test",
+			TokenHash:  hashApiToken(token),
+			Username:   username,
+			SuperUser:  token == superToken,
+			Scopes:     types.TokenScopeRead + "," + types.TokenScopeWrite,
+			ExpireTime: time.Now().Add(time.Hour),
+		})
+		if err != nil {
+			t.Fatalf("cannot insert token: %v", err)
+		}
+	}
+
+	err := store.InsertDeviceRole("worker", []string{"c2", "p1"})
+	if err != nil {
+		t.Fatalf("cannot insert role: %v", err)
+	}
+	err = store.InsertDeviceRole("storage", nil)
+	if err != nil {
+		t.Fatalf("cannot insert role: %v", err)
+	}
+
+	return &testServer{
+		DevopsServer: server,
+		store:        store,
+		cache:        cache,
+		auth:         auth,
+	}
+}
+
+type handler func(w http.ResponseWriter, req *http.Request) (interface{}, string, int)
+
+type handlerOptions struct {
+	token  string
+	secret string
+}
+
+func callHandler(t *testing.T, h handler, path string, input interface{}, opts handlerOptions, output interface{}) (*httptest.ResponseRecorder, string, int) {
+	b, err := json.Marshal(input)
+	if err != nil {
+		t.Fatalf("cannot marshal input: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+	req.RemoteAddr = "10.0.0.1:1234"
+	if opts.token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.token)
+	}
+	if opts.secret != "" {
+		timestamp := time.Now().Unix()
+		nonce := uuid.New().String()
+		req.Header.Set(devopsapi.DeviceTimestampHeader, fmt.Sprintf("%v", timestamp))
+		req.Header.Set(devopsapi.DeviceNonceHeader, nonce)
+		req.Header.Set(devopsapi.DeviceSignatureHeader,
+			devopsapi.SignDeviceRequest(opts.secret, "POST", path, timestamp, nonce, b))
+	}
+
+	w := httptest.NewRecorder()
+	body, msg, code := h(w, req)
+
+	if output != nil && body != nil {
+		b, err = json.Marshal(body)
+		if err != nil {
+			t.Fatalf("cannot marshal output: %v", err)
+		}
+		err = json.Unmarshal(b, output)
+		if err != nil {
+			t.Fatalf("cannot unmarshal output: %v", err)
+		}
+	}
+
+	return w, msg, code
+}
+
+func expectCode(t *testing.T, w *httptest.ResponseRecorder, msg string, code int, expected types.ErrorCode) {
+	t.Helper()
+	if code != expected.Code {
+		t.Fatalf("expect %v, got %v (%v)", expected.Reason, code, msg)
+	}
+	if w.Code != expected.HttpStatus {
+		t.Fatalf("expect http status %v, got %v", expected.HttpStatus, w.Code)
+	}
+	if w.Header().Get(types.ErrorReasonHeader) != expected.Reason {
+		t.Fatalf("expect reason %v, got %v", expected.Reason, w.Header().Get(types.ErrorReasonHeader))
+	}
+}
+
+func expectOk(t *testing.T, msg string, code int) {
+	t.Helper()
+	if code != 0 {
+		t.Fatalf("expect success, got %v (%v)", code, msg)
+	}
+}
+
+func (s *testServer) register(t *testing.T, input types.DeviceRegisterInput, secret string) types.DeviceRegisterOutput {
+	t.Helper()
+	output := types.DeviceRegisterOutput{}
+	_, msg, code := callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI, input,
+		handlerOptions{secret: secret}, &output)
+	expectOk(t, msg, code)
+	return output
+}
+
+func TestDeviceRegister(t *testing.T) {
+	s := newTestServer(t)
+
+	input := types.DeviceRegisterInput{
+		Spec:       "spec-0",
+		ParentSpec: "parent-0",
+		Role:       "worker",
+		SubRole:    "c2",
+		Owner:      "alice",
+		NvmeCount:  2,
+	}
+
+	w, msg, code := callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		types.DeviceRegisterInput{Spec: "spec-0"}, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidParam)
+
+	w, msg, code = callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		types.DeviceRegisterInput{Role: "worker"}, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidParam)
+
+	invalid := input
+	invalid.SubRole = "c1"
+	w, msg, code = callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		invalid, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidParam)
+
+	output := s.register(t, input, "")
+	if output.Id != uuid.NewSHA1(idresolver.DefaultNamespace, []byte("spec-0")) {
+		t.Fatalf("unexpected device id %v", output.Id)
+	}
+	if output.Secret == "" {
+		t.Fatalf("expect a secret at first registration")
+	}
+
+	config, err := s.store.QueryDeviceConfig(output.Id)
+	if err != nil {
+		t.Fatalf("cannot query device: %v", err)
+	}
+	if config.Owner != "alice" || config.Role != "worker" || config.NvmeCount != 2 {
+		t.Fatalf("unexpected device config %v", config)
+	}
+
+	w, msg, code = callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		input, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidSignature)
+
+	w, msg, code = callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		input, handlerOptions{secret: "wrong"}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidSignature)
+
+	input.ParentSpec = "parent-1"
+	again := s.register(t, input, output.Secret)
+	if again.Id != output.Id || again.Secret != "" {
+		t.Fatalf("unexpected output %v", again)
+	}
+
+	config, _ = s.store.QueryDeviceConfig(output.Id)
+	if config.ParentSpec != "parent-0,parent-1" {
+		t.Fatalf("unexpected parent spec %v", config.ParentSpec)
+	}
+
+	logs, _ := s.store.QueryAuditLogs(devopsmysql.AuditFilter{DeviceId: output.Id})
+	if len(logs) != 3 || logs[0].Action != types.AuditActionRegister || logs[1].Action != types.AuditActionSecretIssue {
+		t.Fatalf("unexpected audit logs %v", logs)
+	}
+	if strings.Contains(logs[1].Diff, output.Secret) || !strings.Contains(logs[1].Diff, "issue_time") {
+		t.Fatalf("unexpected secret audit %v", logs[1].Diff)
+	}
+}
+
+func TestDeviceRegisterDecommissioned(t *testing.T) {
+	s := newTestServer(t)
+
+	input := types.DeviceRegisterInput{Spec: "spec-0", ParentSpec: "parent-0", Role: "storage"}
+	device := s.register(t, input, "")
+
+	err := s.store.DecommissionDevice(device.Id, "broken", "admin")
+	if err != nil {
+		t.Fatalf("cannot decommission device: %v", err)
+	}
+
+	input.ParentSpec = "parent-1"
+	w, msg, code := callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		input, handlerOptions{secret: device.Secret}, nil)
+	expectCode(t, w, msg, code, types.ErrDeviceDecommissioned)
+
+	config, _ := s.store.QueryDeviceConfig(device.Id)
+	fresh := *config
+	fresh.Decommissioned = false
+	err = s.store.InsertDeviceConfig(fresh)
+	if err != nil {
+		t.Fatalf("cannot update device: %v", err)
+	}
+	config, _ = s.store.QueryDeviceConfig(device.Id)
+	if !config.Decommissioned {
+		t.Fatalf("expect %v to stay decommissioned", device.Id)
+	}
+}
+
+func TestDecommissionedDeviceUpdates(t *testing.T) {
+	s := newTestServer(t)
+	device := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage", Owner: "admin"}, "")
+
+	err := s.store.DecommissionDevice(device.Id, "broken", "admin")
+	if err != nil {
+		t.Fatalf("cannot decommission device: %v", err)
+	}
+
+	w, msg, code := callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{Maintaining: true, DeviceID: device.Id},
+		handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrDeviceDecommissioned)
+
+	w, msg, code = callHandler(t, s.DeviceTransferRequest, types.DeviceTransferAPI,
+		types.DeviceTransferInput{DeviceID: device.Id, Owner: "alice"},
+		handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrDeviceDecommissioned)
+
+	w, msg, code = callHandler(t, s.MaintenanceCreateRequest, types.MaintenanceCreateAPI,
+		types.MaintenanceCreateInput{
+			DeviceID: device.Id,
+			Start:    time.Now().Unix(),
+			End:      time.Now().Add(time.Hour).Unix(),
+			Reason:   "test",
+		}, handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrDeviceDecommissioned)
+
+	config, _ := s.store.QueryDeviceConfig(device.Id)
+	if config.Maintaining || config.Owner != "admin" {
+		t.Fatalf("unexpected decommissioned device %v", config)
+	}
+}
+
+type unreachableResolver struct{}
+
+func (resolver unreachableResolver) DeviceId(spec string) (uuid.UUID, error) {
+	return uuid.Nil, fmt.Errorf("license service is unreachable")
+}
+
+func TestDeviceRegisterUnresolved(t *testing.T) {
+	s := newTestServer(t)
+	s.deviceIdResolver = unreachableResolver{}
+
+	w, msg, code := callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		types.DeviceRegisterInput{Spec: "spec-0", Role: "storage"}, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrUpstream)
+}
+
+func TestDeviceRegisterLegacy(t *testing.T) {
+	s := newTestServer(t)
+
+	input := types.DeviceRegisterInput{Spec: "spec-0", ParentSpec: "parent-0", Role: "storage"}
+	id := uuid.NewSHA1(idresolver.DefaultNamespace, []byte(input.Spec))
+	err := s.store.InsertDeviceConfig(devopsmysql.DeviceConfig{
+		Id:         id,
+		Spec:       input.Spec,
+		ParentSpec: input.ParentSpec,
+		Role:       input.Role,
+	})
+	if err != nil {
+		t.Fatalf("cannot insert device: %v", err)
+	}
+
+	w, msg, code := callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		input, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidSignature)
+
+	s.config.AllowUnsignedDevice = true
+	input.ParentSpec = "parent-1"
+	output := s.register(t, input, "")
+	if output.Id != id || output.Secret != "" {
+		t.Fatalf("expect no secret for legacy %v, got %v", id, output)
+	}
+
+	rotated := types.DeviceSecretRotateOutput{}
+	_, msg, code = callHandler(t, s.DeviceSecretRotateRequest, types.DeviceSecretRotateAPI,
+		types.DeviceSecretRotateInput{DeviceID: id}, handlerOptions{token: superToken}, &rotated)
+	expectOk(t, msg, code)
+
+	s.config.AllowUnsignedDevice = false
+	_, msg, code = callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+		types.DeviceReportInput{Id: id}, handlerOptions{secret: rotated.Secret}, nil)
+	expectOk(t, msg, code)
+}
+
+func TestDeviceSecretRotate(t *testing.T) {
+	s := newTestServer(t)
+
+	device := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage", Owner: "bob"}, "")
+	err := s.store.SetDeviceMaintaining(device.Id, true)
+	if err != nil {
+		t.Fatalf("cannot set maintaining: %v", err)
+	}
+
+	w, msg, code := callHandler(t, s.DeviceRegisterRequest, types.DeviceRegisterAPI,
+		types.DeviceRegisterInput{Spec: "spec-0", Role: "storage"}, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidSignature)
+
+	input := types.DeviceSecretRotateInput{DeviceID: device.Id}
+	w, msg, code = callHandler(t, s.DeviceSecretRotateRequest, types.DeviceSecretRotateAPI,
+		input, handlerOptions{token: userToken}, nil)
+	expectCode(t, w, msg, code, types.ErrPermissionDenied)
+
+	output := types.DeviceSecretRotateOutput{}
+	_, msg, code = callHandler(t, s.DeviceSecretRotateRequest, types.DeviceSecretRotateAPI,
+		input, handlerOptions{token: superToken}, &output)
+	expectOk(t, msg, code)
+	if output.Secret == "" || output.Secret == device.Secret {
+		t.Fatalf("expect a new secret, got %v", output.Secret)
+	}
+
+	logs, _ := s.store.QueryAuditLogs(devopsmysql.AuditFilter{DeviceId: device.Id})
+	rotated := 0
+	for _, entry := range logs {
+		if strings.Contains(entry.Diff, output.Secret) || strings.Contains(entry.Diff, device.Secret) {
+			t.Fatalf("secret leaked to audit log %v", entry)
+		}
+		if entry.Action == types.AuditActionSecretRotate {
+			rotated++
+		}
+	}
+	if rotated != 1 {
+		t.Fatalf("unexpected audit logs %v", logs)
+	}
+
+	report := types.DeviceReportInput{Id: device.Id}
+	w, msg, code = callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+		report, handlerOptions{secret: device.Secret}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidSignature)
+	_, msg, code = callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+		report, handlerOptions{secret: output.Secret}, nil)
+	expectOk(t, msg, code)
+}
+
+func TestDeviceReport(t *testing.T) {
+	s := newTestServer(t)
+
+	device := s.register(t, types.DeviceRegisterInput{
+		Spec:      "spec-0",
+		Role:      "worker",
+		SubRole:   "c2",
+		Owner:     "alice",
+		NvmeCount: 2,
+	}, "")
+
+	report := types.DeviceReportInput{
+		Id:        device.Id,
+		NvmeCount: 1,
+		LocalAddr: "10.0.0.2",
+	}
+
+	w, msg, code := callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+		report, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidSignature)
+
+	w, msg, code = callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+		types.DeviceReportInput{Id: uuid.New()}, handlerOptions{secret: device.Secret}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidSignature)
+
+	_, msg, code = callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+		report, handlerOptions{secret: device.Secret}, nil)
+	expectOk(t, msg, code)
+
+	runtime, err := s.cache.QueryDevice(device.Id)
+	if err != nil {
+		t.Fatalf("cannot query runtime device: %v", err)
+	}
+	if runtime.NvmeCount != 1 || runtime.LocalAddr != "10.0.0.2" || runtime.Role != "worker" {
+		t.Fatalf("unexpected runtime device %v", runtime)
+	}
+
+	reports, _ := s.store.QueryDeviceReports(device.Id, 10)
+	if len(reports) != 1 {
+		t.Fatalf("expect 1 report, got %v", len(reports))
+	}
+
+	drifts, _ := s.store.QueryDeviceDrifts([]uuid.UUID{device.Id})
+	if len(drifts) != 1 || drifts[0].Item != devopsmysql.DriftItemNvme || drifts[0].Actual != 1 {
+		t.Fatalf("unexpected drifts %v", drifts)
+	}
+
+	heartbeats, _ := s.store.QueryDeviceHeartbeats()
+	if _, ok := heartbeats[device.Id]; !ok {
+		t.Fatalf("expect heartbeat of %v", device.Id)
+	}
+
+	err = s.store.DecommissionDevice(device.Id, "broken", "admin")
+	if err != nil {
+		t.Fatalf("cannot decommission device: %v", err)
+	}
+	w, msg, code = callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+		report, handlerOptions{secret: device.Secret}, nil)
+	expectCode(t, w, msg, code, types.ErrDeviceDecommissioned)
+}
+
+func TestDeviceReportRetention(t *testing.T) {
+	s := newTestServer(t)
+
+	device := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage"}, "")
+	for i := 0; i < 2; i++ {
+		_, msg, code := callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+			types.DeviceReportInput{Id: device.Id}, handlerOptions{secret: device.Secret}, nil)
+		expectOk(t, msg, code)
+	}
+
+	s.pruneDeviceReports()
+	reports, _ := s.store.QueryDeviceReports(device.Id, 0)
+	if len(reports) != 2 {
+		t.Fatalf("expect recent reports kept, got %v", len(reports))
+	}
+
+	pruned, err := s.store.PruneDeviceReports(time.Now().Add(time.Second))
+	if err != nil || pruned != 2 {
+		t.Fatalf("expect 2 reports pruned, got %v: %v", pruned, err)
+	}
+	reports, _ = s.store.QueryDeviceReports(device.Id, 0)
+	if len(reports) != 0 {
+		t.Fatalf("expect no reports, got %v", len(reports))
+	}
+}
+
+func TestDeviceMaintain(t *testing.T) {
+	s := newTestServer(t)
+
+	worker := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "worker", SubRole: "c2"}, "")
+	storage := s.register(t, types.DeviceRegisterInput{Spec: "spec-1", Role: "storage"}, "")
+
+	input := types.MaintainingInput{
+		Maintaining: true,
+		DeviceIDs:   []uuid.UUID{worker.Id, storage.Id},
+	}
+
+	w, msg, code := callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		input, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrUnauthenticated)
+
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		input, handlerOptions{token: userToken}, nil)
+	expectCode(t, w, msg, code, types.ErrPermissionDenied)
+
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{Maintaining: true}, handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidParam)
+
+	unknown := uuid.New()
+	failed := input
+	failed.DeviceIDs = append(failed.DeviceIDs, unknown)
+	output := types.MaintainingOutput{}
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		failed, handlerOptions{token: superToken}, &output)
+	expectCode(t, w, msg, code, types.ErrBatchFailed)
+	if len(output.Results) != 3 || output.Results[2].DeviceID != unknown || output.Results[2].Error == "" {
+		t.Fatalf("unexpected results %v", output.Results)
+	}
+	config, _ := s.store.QueryDeviceConfig(worker.Id)
+	if config.Maintaining {
+		t.Fatalf("batch is not rolled back")
+	}
+
+	output = types.MaintainingOutput{}
+	_, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{
+			Maintaining: true,
+			Selector:    &types.DeviceSelector{Role: "worker"},
+		}, handlerOptions{token: superToken}, &output)
+	expectOk(t, msg, code)
+	if len(output.Results) != 1 || output.Results[0].DeviceID != worker.Id || !output.Results[0].Success {
+		t.Fatalf("unexpected results %v", output.Results)
+	}
+
+	config, _ = s.store.QueryDeviceConfig(worker.Id)
+	if !config.Maintaining {
+		t.Fatalf("expect %v maintaining", worker.Id)
+	}
+	config, _ = s.store.QueryDeviceConfig(storage.Id)
+	if config.Maintaining {
+		t.Fatalf("expect %v not maintaining", storage.Id)
+	}
+}
+
+func TestMyDevicesByAuth(t *testing.T) {
+	s := newTestServer(t)
+
+	ids := []uuid.UUID{}
+	for i := 0; i < 5; i++ {
+		owner := "alice"
+		if i%2 == 1 {
+			owner = "bob"
+		}
+		device := s.register(t, types.DeviceRegisterInput{
+			Spec:    fmt.Sprintf("spec-%v", i),
+			Role:    "worker",
+			SubRole: "p1",
+			Owner:   owner,
+		}, "")
+		ids = append(ids, device.Id)
+
+		_, msg, code := callHandler(t, s.DeviceReportRequest, types.DeviceReportAPI,
+			types.DeviceReportInput{Id: device.Id, GpuCount: i}, handlerOptions{secret: device.Secret}, nil)
+		expectOk(t, msg, code)
+	}
+
+	list := func(token string, filter types.DeviceListFilter) types.MyDevicesOutput {
+		t.Helper()
+		output := types.MyDevicesOutput{}
+		_, msg, code := callHandler(t, s.MyDevicesByAuthRequest, types.MyDevicesByAuthAPI,
+			types.MyDevicesByAuthInput{DeviceListFilter: filter}, handlerOptions{token: token}, &output)
+		expectOk(t, msg, code)
+		return output
+	}
+
+	output := list(userToken, types.DeviceListFilter{})
+	if output.Total != 3 || len(output.Devices) != 3 {
+		t.Fatalf("expect 3 devices of alice, got %v", output.Total)
+	}
+	for _, device := range output.Devices {
+		if device.Owner != "alice" {
+			t.Fatalf("unexpected device of %v", device.Owner)
+		}
+	}
+
+	output = list(superToken, types.DeviceListFilter{})
+	if output.Total != 5 {
+		t.Fatalf("expect 5 devices, got %v", output.Total)
+	}
+
+	output = list(superToken, types.DeviceListFilter{Owner: "bob", SortBy: "spec"})
+	if output.Total != 2 || output.Devices[0].Spec != "spec-1" || output.Devices[1].Spec != "spec-3" {
+		t.Fatalf("unexpected devices %v", output.Devices)
+	}
+
+	output = list(superToken, types.DeviceListFilter{Page: 2, Limit: 2, SortBy: "spec", SortDesc: true})
+	if output.Total != 5 || len(output.Devices) != 2 || output.Devices[0].Spec != "spec-2" {
+		t.Fatalf("unexpected page %v", output.Devices)
+	}
+	if output.Devices[0].Id != ids[2] || output.Devices[0].RuntimeGpuCount != 2 {
+		t.Fatalf("unexpected runtime attributes %v", output.Devices[0])
+	}
+
+	err := s.store.DecommissionDevice(ids[0], "broken", "admin")
+	if err != nil {
+		t.Fatalf("cannot decommission device: %v", err)
+	}
+	output = list(userToken, types.DeviceListFilter{})
+	if output.Total != 2 {
+		t.Fatalf("expect 2 devices, got %v", output.Total)
+	}
+	output = list(userToken, types.DeviceListFilter{IncludeDecommissioned: true})
+	if output.Total != 3 {
+		t.Fatalf("expect 3 devices, got %v", output.Total)
+	}
+
+	w, msg, code := callHandler(t, s.MyDevicesByAuthRequest, types.MyDevicesByAuthAPI,
+		types.MyDevicesByAuthInput{DeviceListFilter: types.DeviceListFilter{SortBy: "unknown"}},
+		handlerOptions{token: userToken}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidParam)
+}
+
+func TestDeviceTopologyByOwner(t *testing.T) {
+	s := newTestServer(t)
+
+	parent := s.register(t, types.DeviceRegisterInput{Spec: "parent-0", Role: "storage", Owner: "admin"}, "")
+	child := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", ParentSpec: "parent-0", Role: "storage", Owner: "alice"}, "")
+	orphan := s.register(t, types.DeviceRegisterInput{Spec: "spec-1", ParentSpec: "gone", Role: "storage", Owner: "alice"}, "")
+
+	output := types.DeviceTopologyOutput{}
+	_, msg, code := callHandler(t, s.DeviceTopologyRequest, types.DeviceTopologyAPI,
+		types.DeviceTopologyInput{}, handlerOptions{token: userToken}, &output)
+	expectOk(t, msg, code)
+	if len(output.Nodes) != 2 {
+		t.Fatalf("expect devices of alice only, got %v", output.Nodes)
+	}
+	if len(output.Roots) != 1 || output.Roots[0] != child.Id {
+		t.Fatalf("unexpected roots %v", output.Roots)
+	}
+	if len(output.Orphans) != 1 || output.Orphans[0] != orphan.Id {
+		t.Fatalf("unexpected orphans %v", output.Orphans)
+	}
+	for _, node := range output.Nodes {
+		if node.Id == child.Id && (len(node.Parents) != 0 || len(node.UnknownParents) != 0) {
+			t.Fatalf("unexpected parents of hidden parent %v", node)
+		}
+	}
+
+	output = types.DeviceTopologyOutput{}
+	_, msg, code = callHandler(t, s.DeviceTopologyRequest, types.DeviceTopologyAPI,
+		types.DeviceTopologyInput{}, handlerOptions{token: superToken}, &output)
+	expectOk(t, msg, code)
+	if len(output.Nodes) != 3 || len(output.Roots) != 1 || output.Roots[0] != parent.Id {
+		t.Fatalf("unexpected topology %v", output)
+	}
+}
+
+func TestDeviceMaintainByOwner(t *testing.T) {
+	s := newTestServer(t)
+	authCode := s.auth.AddUser("carol", "secret", false)
+
+	own := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage", Owner: "carol"}, "")
+	managed := s.register(t, types.DeviceRegisterInput{Spec: "spec-1", Role: "storage", Manager: "carol"}, "")
+	other := s.register(t, types.DeviceRegisterInput{Spec: "spec-2", Role: "storage", Owner: "bob"}, "")
+
+	w, msg, code := callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{AuthCode: authCode, Maintaining: true, DeviceID: own.Id},
+		handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrPermissionDenied)
+
+	s.config.AllowOwnerMaintain = true
+
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{AuthCode: "invalid", Maintaining: true, DeviceID: own.Id},
+		handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrUnauthenticated)
+
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{
+			AuthCode:    authCode,
+			Maintaining: true,
+			DeviceIDs:   []uuid.UUID{own.Id, other.Id},
+		}, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrPermissionDenied)
+
+	output := types.MaintainingOutput{}
+	_, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{
+			AuthCode:    authCode,
+			Maintaining: true,
+			Selector:    &types.DeviceSelector{Role: "storage"},
+		}, handlerOptions{}, &output)
+	expectOk(t, msg, code)
+	if len(output.Results) != 2 {
+		t.Fatalf("expect devices of carol only, got %v", output.Results)
+	}
+
+	for id, maintaining := range map[uuid.UUID]bool{own.Id: true, managed.Id: true, other.Id: false} {
+		config, _ := s.store.QueryDeviceConfig(id)
+		if config.Maintaining != maintaining {
+			t.Fatalf("expect %v maintaining %v", id, maintaining)
+		}
+	}
+
+	s.auth.RevokeAuthCode(authCode)
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		types.MaintainingInput{AuthCode: authCode, DeviceID: own.Id}, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrUnauthenticated)
+}
+
+func TestMyDevicesByUsername(t *testing.T) {
+	s := newTestServer(t)
+	s.auth.AddUser("carol", "secret", false)
+
+	s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage", Owner: "carol"}, "")
+	s.register(t, types.DeviceRegisterInput{Spec: "spec-1", Role: "storage", CurrentUser: "carol"}, "")
+	s.register(t, types.DeviceRegisterInput{Spec: "spec-2", Role: "storage", Owner: "bob"}, "")
+
+	w, msg, code := callHandler(t, s.MyDevicesByUsernameRequest, types.MyDevicesByUsernameAPI,
+		types.MyDevicesByUsernameInput{Username: "carol", Password: "wrong"}, handlerOptions{}, nil)
+	expectCode(t, w, msg, code, types.ErrUnauthenticated)
+
+	output := types.MyDevicesOutput{}
+	_, msg, code = callHandler(t, s.MyDevicesByUsernameRequest, types.MyDevicesByUsernameAPI,
+		types.MyDevicesByUsernameInput{Username: "carol", Password: "secret"}, handlerOptions{}, &output)
+	expectOk(t, msg, code)
+	if output.Total != 2 {
+		t.Fatalf("expect 2 devices of carol, got %v", output.Total)
+	}
+}
+
+func TestDeviceTransferByToken(t *testing.T) {
+	s := newTestServer(t)
+	device := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage", Owner: "admin"}, "")
+
+	w, msg, code := callHandler(t, s.DeviceTransferRequest, types.DeviceTransferAPI,
+		types.DeviceTransferInput{DeviceID: device.Id, Owner: "nobody"},
+		handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidParam)
+
+	_, msg, code = callHandler(t, s.DeviceTransferRequest, types.DeviceTransferAPI,
+		types.DeviceTransferInput{DeviceID: device.Id, Owner: "alice"},
+		handlerOptions{token: superToken}, nil)
+	expectOk(t, msg, code)
+
+	config, _ := s.store.QueryDeviceConfig(device.Id)
+	if config.Owner != "alice" {
+		t.Fatalf("expect owner alice, got %v", config.Owner)
+	}
+}
+
+func TestApiTokenDemoted(t *testing.T) {
+	s := newTestServer(t)
+	device := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage"}, "")
+	input := types.MaintainingInput{Maintaining: true, DeviceID: device.Id}
+
+	s.auth.AddUser("admin", "", false)
+	w, msg, code := callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		input, handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrPermissionDenied)
+
+	authCode := s.auth.AddUser("admin", "", false)
+	_, msg, code = callHandler(t, s.MyDevicesByAuthRequest, types.MyDevicesByAuthAPI,
+		types.MyDevicesByAuthInput{AuthCode: authCode}, handlerOptions{}, nil)
+	expectOk(t, msg, code)
+
+	s.auth.AddUser("admin", "", true)
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		input, handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrUnauthenticated)
+}
+
+// fbcAuthLike hides UserByName, fbc-auth cannot look other users up.
+type fbcAuthLike struct {
+	Authenticator
+}
+
+func TestApiTokenDemotedWithoutResolver(t *testing.T) {
+	s := newTestServer(t)
+	s.authenticator = fbcAuthLike{s.auth}
+	device := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage"}, "")
+	input := types.MaintainingInput{Maintaining: true, DeviceID: device.Id}
+
+	authCode := s.auth.AddUser("admin", "", true)
+	created := types.ApiTokenCreateOutput{}
+	_, msg, code := callHandler(t, s.ApiTokenCreateRequest, types.ApiTokenCreateAPI,
+		types.ApiTokenCreateInput{
+			AuthCode:  authCode,
+			Name:      "ops",
+			Scopes:    []string{types.TokenScopeWrite},
+			SuperUser: true,
+			Expire:    int64(defaultApiTokenExpire / time.Second),
+		}, handlerOptions{}, &created)
+	expectOk(t, msg, code)
+	if created.ExpireTime.After(time.Now().Add(superApiTokenExpire)) {
+		t.Fatalf("super user token expires at %v", created.ExpireTime)
+	}
+
+	_, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		input, handlerOptions{token: created.Token}, nil)
+	expectOk(t, msg, code)
+
+	err := s.store.InsertApiToken(devopsmysql.ApiToken{
+		Id:         uuid.New(),
+		Name:       "legacy",
+		TokenHash:  hashApiToken("fbcdt_legacy"),
+		Username:   "admin",
+		SuperUser:  true,
+		Scopes:     types.TokenScopeWrite,
+		ExpireTime: time.Now().Add(defaultApiTokenExpire),
+	})
+	if err != nil {
+		t.Fatalf("cannot insert token: %v", err)
+	}
+	w, msg, code := callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		input, handlerOptions{token: "fbcdt_legacy"}, nil)
+	expectCode(t, w, msg, code, types.ErrPermissionDenied)
+
+	authCode = s.auth.AddUser("admin", "", false)
+	_, msg, code = callHandler(t, s.MyDevicesByAuthRequest, types.MyDevicesByAuthAPI,
+		types.MyDevicesByAuthInput{AuthCode: authCode}, handlerOptions{}, nil)
+	expectOk(t, msg, code)
+
+	w, msg, code = callHandler(t, s.DeviceMaintainRequest, types.DeviceMaintainAPI,
+		input, handlerOptions{token: created.Token}, nil)
+	expectCode(t, w, msg, code, types.ErrUnauthenticated)
+}
+
+func TestMaintenanceWindowMaintaining(t *testing.T) {
+	s := newTestServer(t)
+	device := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage"}, "")
+
+	schedule := func() uuid.UUID {
+		window := devopsmysql.MaintenanceWindow{
+			Id:        uuid.New(),
+			DeviceId:  device.Id,
+			StartTime: time.Now().Add(-time.Minute),
+			EndTime:   time.Now().Add(time.Hour),
+			Reason:    "test",
+			Operator:  "admin",
+		}
+		err := s.store.InsertMaintenanceWindow(window)
+		if err != nil {
+			t.Fatalf("cannot insert maintenance window: %v", err)
+		}
+		s.checkMaintenanceWindows()
+		return window.Id
+	}
+	cancel := func(id uuid.UUID) {
+		_, msg, code := callHandler(t, s.MaintenanceCancelRequest, types.MaintenanceCancelAPI,
+			types.MaintenanceCancelInput{Id: id}, handlerOptions{token: superToken}, nil)
+		expectOk(t, msg, code)
+	}
+	expectMaintaining := func(maintaining bool) {
+		t.Helper()
+		config, _ := s.store.QueryDeviceConfig(device.Id)
+		if config.Maintaining != maintaining {
+			t.Fatalf("expect maintaining %v", maintaining)
+		}
+	}
+
+	first := schedule()
+	second := schedule()
+	expectMaintaining(true)
+	cancel(first)
+	expectMaintaining(true)
+	cancel(second)
+	expectMaintaining(false)
+
+	err := s.store.SetDeviceMaintaining(device.Id, true)
+	if err != nil {
+		t.Fatalf("cannot set maintaining: %v", err)
+	}
+	cancel(schedule())
+	expectMaintaining(true)
+}
+
+func TestMaintenanceWindowDecommissioned(t *testing.T) {
+	s := newTestServer(t)
+	active := s.register(t, types.DeviceRegisterInput{Spec: "spec-0", Role: "storage"}, "")
+	scheduled := s.register(t, types.DeviceRegisterInput{Spec: "spec-1", Role: "storage"}, "")
+
+	windows := map[uuid.UUID]uuid.UUID{}
+	for _, id := range []uuid.UUID{active.Id, scheduled.Id} {
+		window := devopsmysql.MaintenanceWindow{
+			Id:        uuid.New(),
+			DeviceId:  id,
+			StartTime: time.Now().Add(-time.Minute),
+			EndTime:   time.Now().Add(time.Hour),
+			Reason:    "test",
+			Operator:  "admin",
+		}
+		err := s.store.InsertMaintenanceWindow(window)
+		if err != nil {
+			t.Fatalf("cannot insert maintenance window: %v", err)
+		}
+		windows[id] = window.Id
+		if id == active.Id {
+			s.checkMaintenanceWindows()
+		}
+	}
+
+	_, msg, code := callHandler(t, s.DeviceDecommissionRequest, types.DeviceDecommissionAPI,
+		types.DeviceDecommissionInput{DeviceID: active.Id, Reason: "broken"},
+		handlerOptions{token: superToken}, nil)
+	expectOk(t, msg, code)
+
+	err := s.store.DecommissionDevice(scheduled.Id, "broken", "admin")
+	if err != nil {
+		t.Fatalf("cannot decommission device: %v", err)
+	}
+	s.checkMaintenanceWindows()
+
+	for _, id := range []uuid.UUID{active.Id, scheduled.Id} {
+		window, _ := s.store.QueryMaintenanceWindow(windows[id])
+		if window.Status != devopsmysql.MaintenanceCancelled {
+			t.Fatalf("expect maintenance of %v cancelled, got %v", id, window.Status)
+		}
+		config, _ := s.store.QueryDeviceConfig(id)
+		if config.Maintaining {
+			t.Fatalf("expect %v not maintaining", id)
+		}
+	}
+}
+
+func TestAlertMgrAddressSubRole(t *testing.T) {
+	s := newTestServer(t)
+
+	w, msg, code := callHandler(t, s.DevopsAlertMgrAddressPostRequest, types.DevopsAlertMgrAddressAPI,
+		types.DevopsAlertMgrAddressPostInput{
+			AlertMgrAddress: types.AlertMgrAddress{Address: "10.0.0.2:9093", SubRole: "c2"},
+		}, handlerOptions{token: superToken}, nil)
+	expectCode(t, w, msg, code, types.ErrInvalidParam)
+
+	_, msg, code = callHandler(t, s.DevopsAlertMgrAddressPostRequest, types.DevopsAlertMgrAddressAPI,
+		types.DevopsAlertMgrAddressPostInput{
+			AlertMgrAddress: types.AlertMgrAddress{Address: "10.0.0.2:9093", Role: "worker", SubRole: "c2"},
+		}, handlerOptions{token: superToken}, nil)
+	expectOk(t, msg, code)
+
+	addrs, _ := s.alertMgrAddresses()
+	addr := matchAlertMgrAddress(addrs, "worker", "c2", nil)
+	if addr == nil || addr.Address != "10.0.0.2:9093" {
+		t.Fatalf("unexpected alertmanager address %v", addr)
+	}
+}
+
+func TestDeviceAddressesByUser(t *testing.T) {
+	s := newTestServer(t)
+	s.register(t, types.DeviceRegisterInput{
+		Spec: "spec-0", Role: "storage", Owner: "alice", LocalAddr: "10.0.0.2:9100", PublicAddr: "1.2.3.4",
+	}, "")
+	s.register(t, types.DeviceRegisterInput{
+		Spec: "spec-1", Role: "storage", Owner: "bob", LocalAddr: "10.0.0.3",
+	}, "")
+
+	addrs := s.deviceAddressesByUser(&authtypes.UserInfoOutput{Username: "alice"})
+	if len(addrs) != 2 {
+		t.Fatalf("unexpected addresses %v", addrs)
+	}
+	for _, addr := range []string{"10.0.0.2", "1.2.3.4"} {
+		if _, ok := addrs[addr]; !ok {
+			t.Fatalf("expect address %v in %v", addr, addrs)
+		}
+	}
+}
+
+func TestSourceIp(t *testing.T) {
+	s := newTestServer(t)
+	s.trustedProxies = parseTrustedProxies([]string{"10.0.0.1", "192.168.0.0/16", "invalid"})
+
+	for _, c := range []struct {
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"1.2.3.4:1234", "", "1.2.3.4"},
+		{"1.2.3.4:1234", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:1234", "", "10.0.0.1"},
+		{"10.0.0.1:1234", "5.6.7.8", "5.6.7.8"},
+		{"10.0.0.1:1234", "9.9.9.9, 5.6.7.8, 192.168.1.1", "5.6.7.8"},
+		{"10.0.0.2:1234", "5.6.7.8", "10.0.0.2"},
+	} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.RemoteAddr = c.remoteAddr
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if source := s.sourceIp(req); source != c.expected {
+			t.Fatalf("expect %v from %v [%v], got %v", c.expected, c.remoteAddr, c.forwarded, source)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"net/http"
+
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+)
+
+func fail(w http.ResponseWriter, code types.ErrorCode, msg string) (interface{}, string, int) {
+	return failWith(w, nil, code, msg)
+}
+
+func failWith(w http.ResponseWriter, body interface{}, code types.ErrorCode, msg string) (interface{}, string, int) {
+	w.Header().Set(types.ErrorReasonHeader, code.Reason)
+	w.WriteHeader(code.HttpStatus)
+	return body, msg, code.Code
+}
@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/xerrors"
+)
+
+type SilenceMatcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+}
+
+type silence struct {
+	Matchers  []SilenceMatcher `json:"matchers"`
+	StartsAt  time.Time        `json:"startsAt"`
+	EndsAt    time.Time        `json:"endsAt"`
+	CreatedBy string           `json:"createdBy"`
+	Comment   string           `json:"comment"`
+}
+
+type silenceResponse struct {
+	SilenceID string `json:"silenceID"`
+}
+
+type AlertMgrCli struct {
+	client *http.Client
+}
+
+func NewAlertMgrCli(timeout time.Duration) *AlertMgrCli {
+	return &AlertMgrCli{
+		client: &http.Client{
+			Timeout: timeout,
+		},
+	}
+}
+
+func alertMgrUrl(address string, api string) string {
+	if !strings.Contains(address, "://") {
+		address = fmt.Sprintf("http://%v", address)
+	}
+	return fmt.Sprintf("%v%v", strings.TrimRight(address, "/"), api)
+}
+
+func (cli *AlertMgrCli) do(req *http.Request) ([]byte, error) {
+	resp, err := cli.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, xerrors.Errorf("alertmanager return %v: %v", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+func (cli *AlertMgrCli) CreateSilence(address string, matchers []SilenceMatcher, start, end time.Time, createdBy, comment string) (string, error) {
+	b, err := json.Marshal(silence{
+		Matchers:  matchers,
+		StartsAt:  start,
+		EndsAt:    end,
+		CreatedBy: createdBy,
+		Comment:   comment,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequest("POST", alertMgrUrl(address, "/api/v2/silences"), bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	body, err := cli.do(req)
+	if err != nil {
+		return "", err
+	}
+
+	resp := silenceResponse{}
+	err = json.Unmarshal(body, &resp)
+	if err != nil {
+		return "", err
+	}
+
+	return resp.SilenceID, nil
+}
+
+func (cli *AlertMgrCli) ExpireSilence(address string, id string) error {
+	req, err := http.NewRequest("DELETE", alertMgrUrl(address, fmt.Sprintf("/api/v2/silence/%v", id)), nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = cli.do(req)
+	return err
+}
@@ -1,16 +1,146 @@
 package gateway
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 
 	log "github.com/EntropyPool/entropy-logger"
 	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	etcdcli "github.com/NpoolDevOps/fbc-license-service/etcdcli"
+	"golang.org/x/xerrors"
 )
 
+type PrometheusTLSConfig struct {
+	CaFile             string `json:"ca_file"`
+	CertFile           string `json:"cert_file"`
+	KeyFile            string `json:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"`
+}
+
+type PrometheusConfig struct {
+	Url         string              `json:"url"`
+	Timeout     int                 `json:"timeout"`
+	Username    string              `json:"username"`
+	Password    string              `json:"password"`
+	BearerToken string              `json:"bearer_token"`
+	TLS         PrometheusTLSConfig `json:"tls"`
+}
+
+type PrometheusCli struct {
+	config PrometheusConfig
+	client *http.Client
+}
+
+func NewPrometheusCli(config PrometheusConfig) *PrometheusCli {
+	var myConfig PrometheusConfig
+
+	resp, err := etcdcli.Get(config.Url)
+	if err == nil {
+		err = json.Unmarshal(resp[0], &myConfig)
+		if err == nil {
+			config = myConfig
+		}
+	}
+
+	if config.Url == "" {
+		log.Errorf(log.Fields{}, "prometheus url is must")
+		return nil
+	}
+	if !strings.Contains(config.Url, "://") {
+		config.Url = fmt.Sprintf("http://%v", config.Url)
+	}
+	config.Url = strings.TrimRight(config.Url, "/")
+
+	if config.Timeout <= 0 {
+		config.Timeout = 30
+	}
+
+	tlsConfig, err := newTLSConfig(config.TLS)
+	if err != nil {
+		log.Errorf(log.Fields{}, "cannot create prometheus tls config: %v", err)
+		return nil
+	}
+
+	log.Infof(log.Fields{}, "prometheus server -> %v", config.Url)
+
+	return &PrometheusCli{
+		config: config,
+		client: &http.Client{
+			Timeout: time.Duration(config.Timeout) * time.Second,
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+		},
+	}
+}
+
+func newTLSConfig(config PrometheusTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+
+	if config.CaFile != "" {
+		b, err := ioutil.ReadFile(config.CaFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, xerrors.Errorf("invalid ca file %v", config.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func (cli *PrometheusCli) get(api string, params url.Values, result interface{}) error {
+	req, err := http.NewRequest("GET", fmt.Sprintf("%v%v?%v", cli.config.Url, api, params.Encode()), nil)
+	if err != nil {
+		return err
+	}
+
+	if cli.config.BearerToken != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", cli.config.BearerToken))
+	} else if cli.config.Username != "" {
+		req.SetBasicAuth(cli.config.Username, cli.config.Password)
+	}
+
+	resp, err := cli.client.Do(req)
+	if err != nil {
+		log.Errorf(log.Fields{}, "get info from prometheus err: %v", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return xerrors.Errorf("prometheus return %v: %v", resp.StatusCode, string(body))
+	}
+
+	return json.Unmarshal(body, result)
+}
+
 type Response struct {
 	Status string `json:"status"`
 	Data   Data   `json:"data"`
@@ -21,8 +151,9 @@ type Data struct {
 }
 
 type Result struct {
-	Metric Metric        `json:"metric"`
-	Value  []interface{} `json:"value"`
+	Metric Metric          `json:"metric"`
+	Value  []interface{}   `json:"value"`
+	Values [][]interface{} `json:"values"`
 }
 
 type Metric struct {
@@ -30,20 +161,45 @@ type Metric struct {
 	Job      string `json:"job"`
 }
 
-func GetMetrics(metrics []string) ([]types.Outresp, error) {
-	var output []types.Outresp
-	for _, metric := range metrics {
-		result := Response{}
-		resp, err := http.Get(fmt.Sprintf("http://47.99.107.242:9090/api/v1/query?query=%v", metric))
-		if err != nil {
-			log.Errorf(log.Fields{}, "get info from prometheus err: %v", err)
-			return nil, err
+const defaultRangeStep = "60"
+
+func (cli *PrometheusCli) GetMetrics(metrics []string) ([]types.Outresp, error) {
+	return cli.QueryMetrics(types.MetricInput{
+		Metrics: metrics,
+	})
+}
+
+func (cli *PrometheusCli) QueryMetrics(input types.MetricInput) ([]types.Outresp, error) {
+	api := "/api/v1/query"
+	params := url.Values{}
+
+	if input.Start > 0 {
+		end := input.End
+		if end <= 0 {
+			end = time.Now().Unix()
 		}
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
+		if end < input.Start {
+			return nil, xerrors.Errorf("end %v is before start %v", end, input.Start)
+		}
+
+		step := input.Step
+		if step == "" {
+			step = defaultRangeStep
 		}
-		err = json.Unmarshal(body, &result)
+
+		api = "/api/v1/query_range"
+		params.Set("start", fmt.Sprintf("%v", input.Start))
+		params.Set("end", fmt.Sprintf("%v", end))
+		params.Set("step", step)
+	} else if input.End > 0 {
+		params.Set("time", fmt.Sprintf("%v", input.End))
+	}
+
+	var output []types.Outresp
+	for _, metric := range input.Metrics {
+		result := Response{}
+		params.Set("query", metric)
+		err := cli.get(api, params, &result)
 		if err != nil {
 			return nil, err
 		}
@@ -54,7 +210,24 @@ func GetMetrics(metrics []string) ([]types.Outresp, error) {
 			mymetric := types.MyMetric{}
 			mymetric.Instance = strings.TrimSpace(strings.Split(v.Metric.Instance, ":")[0])
 			mymetric.Job = v.Metric.Job
-			mymetric.Value = v.Value[1].(string)
+
+			if len(v.Value) == 2 {
+				mymetric.Value, _ = v.Value[1].(string)
+			}
+
+			for _, value := range v.Values {
+				sample, err := parseSample(value)
+				if err != nil {
+					return nil, err
+				}
+				mymetric.Values = append(mymetric.Values, sample)
+			}
+
+			// Keep the latest sample in Value so range consumers that only
+			// read the single value still get something meaningful
+			if len(mymetric.Values) > 0 {
+				mymetric.Value = mymetric.Values[len(mymetric.Values)-1].Value
+			}
 
 			outputResp.Metric = append(outputResp.Metric, mymetric)
 			outputResp.MetricName = metric
@@ -64,3 +237,24 @@ func GetMetrics(metrics []string) ([]types.Outresp, error) {
 	}
 	return output, nil
 }
+
+func parseSample(value []interface{}) (types.MetricSample, error) {
+	sample := types.MetricSample{}
+	if len(value) != 2 {
+		return sample, xerrors.Errorf("invalid sample %v", value)
+	}
+
+	ts, ok := value[0].(float64)
+	if !ok {
+		return sample, xerrors.Errorf("invalid sample timestamp %v", value[0])
+	}
+	val, ok := value[1].(string)
+	if !ok {
+		return sample, xerrors.Errorf("invalid sample value %v", value[1])
+	}
+
+	sample.Timestamp = ts
+	sample.Value = val
+
+	return sample, nil
+}
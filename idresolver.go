@@ -0,0 +1,41 @@
+package main
+
+import (
+	"github.com/NpoolDevOps/fbc-devops-service/idresolver"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+const (
+	DeviceIdResolverLicense = "license"
+	DeviceIdResolverLocal   = "local"
+)
+
+// DeviceIdResolver maps the spec reported at registration to the device id,
+// see idresolver for the license service and local implementations.
+type DeviceIdResolver interface {
+	DeviceId(spec string) (uuid.UUID, error)
+}
+
+var (
+	_ DeviceIdResolver = (*idresolver.License)(nil)
+	_ DeviceIdResolver = (*idresolver.Local)(nil)
+)
+
+func newDeviceIdResolver(config DevopsConfig) (DeviceIdResolver, error) {
+	switch config.DeviceIdResolver {
+	case "", DeviceIdResolverLicense:
+		return idresolver.NewLicense(), nil
+	case DeviceIdResolverLocal:
+		namespace := uuid.Nil
+		if config.DeviceIdNamespace != "" {
+			var err error
+			namespace, err = uuid.Parse(config.DeviceIdNamespace)
+			if err != nil {
+				return nil, xerrors.Errorf("invalid device id namespace %v: %v", config.DeviceIdNamespace, err)
+			}
+		}
+		return idresolver.NewLocal(namespace), nil
+	}
+	return nil, xerrors.Errorf("invalid device id resolver %v", config.DeviceIdResolver)
+}
@@ -0,0 +1,25 @@
+package idresolver
+
+import (
+	licapi "github.com/NpoolDevOps/fbc-license-service/licenseapi"
+	lictypes "github.com/NpoolDevOps/fbc-license-service/types"
+	"github.com/google/uuid"
+)
+
+// License asks fbc-license-service for the client id of a spec, it is the
+// default resolver.
+type License struct{}
+
+func NewLicense() *License {
+	return &License{}
+}
+
+func (resolver *License) DeviceId(spec string) (uuid.UUID, error) {
+	clientInfo, err := licapi.ClientInfoBySpec(lictypes.ClientInfoBySpecInput{
+		Spec: spec,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return clientInfo.Id, nil
+}
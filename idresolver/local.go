@@ -0,0 +1,33 @@
+package idresolver
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// DefaultNamespace is used by Local when the deployment does not pick its own.
+var DefaultNamespace = uuid.MustParse("6c1e0f4a-8d0b-5b8e-9a55-3f1f0d5c2b7e")
+
+// Local derives the device id as the UUIDv5 of the spec, so that standalone
+// deployments register devices without fbc-license-service. Ids differ from
+// the ones issued by the license service, do not switch resolvers on a site
+// with registered devices.
+type Local struct {
+	namespace uuid.UUID
+}
+
+func NewLocal(namespace uuid.UUID) *Local {
+	if namespace == uuid.Nil {
+		namespace = DefaultNamespace
+	}
+	return &Local{
+		namespace: namespace,
+	}
+}
+
+func (resolver *Local) DeviceId(spec string) (uuid.UUID, error) {
+	if spec == "" {
+		return uuid.Nil, xerrors.Errorf("spec is must")
+	}
+	return uuid.NewSHA1(resolver.namespace, []byte(spec)), nil
+}
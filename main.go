@@ -19,6 +19,15 @@ func main() {
 				Value: "./fbc-devops-service.conf",
 			},
 		},
+		Commands: []*cli.Command{
+			migrateCmd,
+			listCmd,
+			showCmd,
+			maintainCmd,
+			transferCmd,
+			rolesCmd,
+			exportCmd,
+		},
 		Action: func(cctx *cli.Context) error {
 			configFile := cctx.String("config")
 			server := NewDevopsServer(configFile)
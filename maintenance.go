@@ -0,0 +1,408 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	"github.com/NpoolDevOps/fbc-devops-service/gateway"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+const (
+	maintenanceSchedulerInterval = 30 * time.Second
+	maintenanceSchedulerActor    = "scheduler"
+)
+
+func maintenanceWindow(info devopsmysql.MaintenanceWindow) types.MaintenanceWindow {
+	return types.MaintenanceWindow{
+		Id:        info.Id,
+		DeviceID:  info.DeviceId,
+		Start:     info.StartTime,
+		End:       info.EndTime,
+		Reason:    info.Reason,
+		Operator:  info.Operator,
+		Status:    info.Status,
+		SilenceId: info.SilenceId,
+	}
+}
+
+func (s *DevopsServer) silenceDevice(window *devopsmysql.MaintenanceWindow) error {
+	config, err := s.deviceStore.QueryDeviceConfig(window.DeviceId)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := s.alertMgrAddresses()
+	if err != nil {
+		return err
+	}
+
+	parentSpecs := []string{}
+	if config.ParentSpec != "" {
+		parentSpecs = strings.Split(config.ParentSpec, ",")
+	}
+
+	addr := matchAlertMgrAddress(addrs, config.Role, config.SubRole, parentSpecs)
+	if addr == nil {
+		log.Infof(log.Fields{}, "no alertmanager for %v, skip silence", window.DeviceId)
+		return nil
+	}
+
+	device, err := s.runtimeCache.QueryDevice(window.DeviceId)
+	if err != nil {
+		log.Infof(log.Fields{}, "no runtime address of %v, skip silence: %v", window.DeviceId, err)
+		return nil
+	}
+
+	instances := []string{}
+	for _, ip := range []string{device.LocalAddr, device.PublicAddr} {
+		ip = strings.TrimSpace(strings.Split(ip, ":")[0])
+		if ip != "" {
+			instances = append(instances, regexp.QuoteMeta(ip))
+		}
+	}
+	if len(instances) == 0 {
+		return nil
+	}
+
+	id, err := s.alertMgrClient.CreateSilence(addr.Address, []gateway.SilenceMatcher{
+		{
+			Name:    "instance",
+			Value:   fmt.Sprintf("(%v)(:[0-9]+)?", strings.Join(instances, "|")),
+			IsRegex: true,
+		},
+	}, time.Now(), window.EndTime, window.Operator, window.Reason)
+	if err != nil {
+		return err
+	}
+
+	window.SilenceAddress = addr.Address
+	window.SilenceId = id
+
+	return nil
+}
+
+// windowsSetMaintaining tells whether another active window of the device
+// set its maintaining flag.
+func (s *DevopsServer) windowsSetMaintaining(deviceId uuid.UUID, except uuid.UUID) (bool, error) {
+	windows, err := s.deviceStore.QueryMaintenanceWindows([]uuid.UUID{deviceId})
+	if err != nil {
+		return false, err
+	}
+	for _, window := range windows {
+		if window.Id != except && window.Status == devopsmysql.MaintenanceActive && window.SetMaintaining {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// enterMaintenance only takes over the maintaining flag when it is not set by
+// an operator, so that exitMaintenance leaves the operator's flag alone.
+func (s *DevopsServer) enterMaintenance(window devopsmysql.MaintenanceWindow) error {
+	before := s.deviceSnapshot(window.DeviceId)
+
+	config, err := s.deviceStore.QueryDeviceConfig(window.DeviceId)
+	if err != nil {
+		return err
+	}
+
+	if config.Decommissioned {
+		log.Infof(log.Fields{}, "device %v is decommissioned, cancel maintenance %v", window.DeviceId, window.Id)
+		window.Status = devopsmysql.MaintenanceCancelled
+		return s.deviceStore.UpdateMaintenanceWindow(window)
+	}
+
+	window.SetMaintaining = !config.Maintaining
+	if config.Maintaining {
+		window.SetMaintaining, err = s.windowsSetMaintaining(window.DeviceId, window.Id)
+		if err != nil {
+			return err
+		}
+	}
+
+	if window.SetMaintaining {
+		err = s.deviceStore.SetDeviceMaintaining(window.DeviceId, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = s.silenceDevice(&window)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to silence %v: %v", window.DeviceId, err)
+	}
+
+	window.Status = devopsmysql.MaintenanceActive
+	err = s.deviceStore.UpdateMaintenanceWindow(window)
+	if err != nil {
+		return err
+	}
+
+	s.recordAudit("", maintenanceSchedulerActor, types.AuditActionMaintain,
+		window.DeviceId, before, s.deviceSnapshot(window.DeviceId))
+
+	return nil
+}
+
+func (s *DevopsServer) exitMaintenance(window devopsmysql.MaintenanceWindow, status string) error {
+	if window.Status == devopsmysql.MaintenanceActive && window.SetMaintaining {
+		count, err := s.deviceStore.CountActiveMaintenanceWindows(window.DeviceId, window.Id)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 {
+			before := s.deviceSnapshot(window.DeviceId)
+
+			err = s.deviceStore.SetDeviceMaintaining(window.DeviceId, false)
+			if err != nil {
+				return err
+			}
+
+			s.recordAudit("", maintenanceSchedulerActor, types.AuditActionMaintain,
+				window.DeviceId, before, s.deviceSnapshot(window.DeviceId))
+		}
+	}
+
+	if window.SilenceId != "" {
+		err := s.alertMgrClient.ExpireSilence(window.SilenceAddress, window.SilenceId)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to expire silence %v of %v: %v", window.SilenceId, window.DeviceId, err)
+		}
+	}
+
+	window.Status = status
+	return s.deviceStore.UpdateMaintenanceWindow(window)
+}
+
+// cancelMaintenanceWindows cancels the scheduled and active windows of a
+// device which leaves service.
+func (s *DevopsServer) cancelMaintenanceWindows(req *http.Request, actor string, deviceId uuid.UUID) {
+	windows, err := s.deviceStore.QueryMaintenanceWindows([]uuid.UUID{deviceId})
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query maintenance windows of %v: %v", deviceId, err)
+		return
+	}
+
+	for _, window := range windows {
+		if window.Status != devopsmysql.MaintenanceScheduled && window.Status != devopsmysql.MaintenanceActive {
+			continue
+		}
+
+		before := maintenanceWindow(window)
+		err = s.exitMaintenance(window, devopsmysql.MaintenanceCancelled)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to cancel maintenance %v of %v: %v", window.Id, deviceId, err)
+			continue
+		}
+
+		window.Status = devopsmysql.MaintenanceCancelled
+		s.audit(req, actor, types.AuditActionMaintenanceCancel,
+			deviceId, before, maintenanceWindow(window))
+	}
+}
+
+func (s *DevopsServer) checkMaintenanceWindows() {
+	now := time.Now()
+
+	windows, err := s.deviceStore.QueryExpiredMaintenanceWindows(now)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query expired maintenance windows: %v", err)
+	}
+	for _, window := range windows {
+		err = s.exitMaintenance(window, devopsmysql.MaintenanceFinished)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to finish maintenance %v of %v: %v", window.Id, window.DeviceId, err)
+		}
+	}
+
+	windows, err = s.deviceStore.QueryDueMaintenanceWindows(now)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query due maintenance windows: %v", err)
+	}
+	for _, window := range windows {
+		err = s.enterMaintenance(window)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to start maintenance %v of %v: %v", window.Id, window.DeviceId, err)
+		}
+	}
+}
+
+func (s *DevopsServer) maintenanceScheduler() {
+	ticker := time.NewTicker(maintenanceSchedulerInterval)
+	for range ticker.C {
+		s.checkMaintenanceWindows()
+	}
+}
+
+func (s *DevopsServer) MaintenanceCreateRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.MaintenanceCreateInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	if input.DeviceID == uuid.Nil {
+		return fail(w, types.ErrInvalidParam, "device id is must")
+	}
+
+	if input.Reason == "" {
+		return fail(w, types.ErrInvalidParam, "reason is must")
+	}
+
+	start := time.Unix(input.Start, 0)
+	end := time.Unix(input.End, 0)
+	if input.Start <= 0 || !end.After(start) || !end.After(time.Now()) {
+		return fail(w, types.ErrInvalidParam, "invalid maintenance window")
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	if !user.SuperUser {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	config, err := s.deviceStore.QueryDeviceConfig(input.DeviceID)
+	if err != nil {
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if config.Decommissioned {
+		return fail(w, types.ErrDeviceDecommissioned, "device is decommissioned")
+	}
+
+	window := devopsmysql.MaintenanceWindow{
+		Id:        uuid.New(),
+		DeviceId:  input.DeviceID,
+		StartTime: start,
+		EndTime:   end,
+		Reason:    input.Reason,
+		Operator:  user.Username,
+	}
+
+	err = s.deviceStore.InsertMaintenanceWindow(window)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	window.Status = devopsmysql.MaintenanceScheduled
+	s.audit(req, user.Username, types.AuditActionMaintenanceSchedule,
+		input.DeviceID, nil, maintenanceWindow(window))
+
+	return maintenanceWindow(window), "", 0
+}
+
+func (s *DevopsServer) MaintenanceCancelRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.MaintenanceCancelInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	if !user.SuperUser {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	window, err := s.deviceStore.QueryMaintenanceWindow(input.Id)
+	if err != nil {
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if window.Status != devopsmysql.MaintenanceScheduled && window.Status != devopsmysql.MaintenanceActive {
+		return fail(w, types.ErrConflict, fmt.Sprintf("maintenance window is %v", window.Status))
+	}
+
+	before := maintenanceWindow(*window)
+
+	err = s.exitMaintenance(*window, devopsmysql.MaintenanceCancelled)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	window.Status = devopsmysql.MaintenanceCancelled
+	s.audit(req, user.Username, types.AuditActionMaintenanceCancel,
+		window.DeviceId, before, maintenanceWindow(*window))
+
+	return nil, "", 0
+}
+
+func (s *DevopsServer) MaintenancesRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.MaintenancesInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	var deviceIds []uuid.UUID
+
+	if !user.SuperUser {
+		infos, _, err := s.deviceStore.QueryDeviceConfigsByFilter(visibleDeviceFilter(user, devopsmysql.DeviceFilter{
+			IncludeDecommissioned: true,
+		}))
+		if err != nil {
+			return fail(w, types.ErrStorage, err.Error())
+		}
+		deviceIds = []uuid.UUID{}
+		for _, info := range infos {
+			if input.DeviceID == uuid.Nil || input.DeviceID == info.Id {
+				deviceIds = append(deviceIds, info.Id)
+			}
+		}
+		if len(deviceIds) == 0 {
+			return fail(w, types.ErrPermissionDenied, "permission denied")
+		}
+	} else if input.DeviceID != uuid.Nil {
+		deviceIds = []uuid.UUID{input.DeviceID}
+	}
+
+	infos, err := s.deviceStore.QueryMaintenanceWindows(deviceIds)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	output := types.MaintenancesOutput{
+		Windows: []types.MaintenanceWindow{},
+	}
+	for _, info := range infos {
+		output.Windows = append(output.Windows, maintenanceWindow(info))
+	}
+
+	return output, "", 0
+}
@@ -0,0 +1,144 @@
+package memstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+)
+
+type cacheEntry struct {
+	value  []byte
+	expire time.Time
+}
+
+// RuntimeCache mimics fbcredis.RedisCli, missing keys are reported with
+// redis.Nil so callers cannot tell the two apart.
+type RuntimeCache struct {
+	mutex   sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewRuntimeCache() *RuntimeCache {
+	return &RuntimeCache{
+		entries: map[string]cacheEntry{},
+	}
+}
+
+func (cache *RuntimeCache) set(key string, value []byte, ttl time.Duration) {
+	entry := cacheEntry{
+		value: value,
+	}
+	if ttl > 0 {
+		entry.expire = time.Now().Add(ttl)
+	}
+	cache.entries[key] = entry
+}
+
+func (cache *RuntimeCache) get(key string) ([]byte, bool) {
+	entry, ok := cache.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !entry.expire.IsZero() && time.Now().After(entry.expire) {
+		delete(cache.entries, key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+func (cache *RuntimeCache) InsertKeyInfo(keyWord string, id uuid.UUID, info interface{}, ttl time.Duration) error {
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.set(fmt.Sprintf("%v:%v", keyWord, id), b, ttl)
+	return nil
+}
+
+func (cache *RuntimeCache) DeleteKeyInfo(keyWord string, id uuid.UUID) error {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	delete(cache.entries, fmt.Sprintf("%v:%v", keyWord, id))
+	return nil
+}
+
+func (cache *RuntimeCache) QueryDevice(cid uuid.UUID) (*types.DeviceConfig, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	b, ok := cache.get(fmt.Sprintf("device:%v", cid))
+	if !ok {
+		return nil, redis.Nil
+	}
+
+	info := &types.DeviceConfig{}
+	err := json.Unmarshal(b, info)
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (cache *RuntimeCache) QueryDevices(cids []uuid.UUID) (map[uuid.UUID]*types.DeviceConfig, error) {
+	infos := map[uuid.UUID]*types.DeviceConfig{}
+	for _, cid := range cids {
+		info, err := cache.QueryDevice(cid)
+		if err != nil {
+			continue
+		}
+		infos[cid] = info
+	}
+	return infos, nil
+}
+
+func (cache *RuntimeCache) InsertAlertMgrAddresses(infos []types.AlertMgrAddress, ttl time.Duration) error {
+	b, err := json.Marshal(infos)
+	if err != nil {
+		return err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.set("alertmgr:addresses", b, ttl)
+	return nil
+}
+
+func (cache *RuntimeCache) QueryAlertMgrAddresses() ([]types.AlertMgrAddress, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	b, ok := cache.get("alertmgr:addresses")
+	if !ok {
+		return nil, redis.Nil
+	}
+
+	infos := []types.AlertMgrAddress{}
+	err := json.Unmarshal(b, &infos)
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (cache *RuntimeCache) InsertNonce(id uuid.UUID, nonce string, ttl time.Duration) (bool, error) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	key := fmt.Sprintf("nonce:%v:%v", id, nonce)
+	if _, ok := cache.get(key); ok {
+		return false, nil
+	}
+	cache.set(key, []byte("1"), ttl)
+	return true, nil
+}
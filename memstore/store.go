@@ -0,0 +1,886 @@
+package memstore
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+// DeviceStore keeps everything the mysql backend does in process memory, it
+// follows the semantics of devopsmysql.MysqlCli closely enough for handler
+// tests and standalone demos.
+type DeviceStore struct {
+	mutex          sync.Mutex
+	configs        map[uuid.UUID]devopsmysql.DeviceConfig
+	roles          map[string]devopsmysql.DeviceRole
+	reports        []devopsmysql.DeviceReport
+	drifts         []devopsmysql.DeviceDrift
+	heartbeats     map[uuid.UUID]time.Time
+	statusChanges  []devopsmysql.DeviceStatusChange
+	decommissions  []devopsmysql.DeviceDecommission
+	ownerships     []devopsmysql.DeviceOwnership
+	alertMgrs      map[uuid.UUID]devopsmysql.AlertMgrAddress
+	secrets        map[uuid.UUID]devopsmysql.DeviceSecret
+	tokens         map[uuid.UUID]devopsmysql.ApiToken
+	auditLogs      []devopsmysql.AuditLog
+	maintenances   map[uuid.UUID]devopsmysql.MaintenanceWindow
+	maintenanceIds []uuid.UUID
+}
+
+func NewDeviceStore() *DeviceStore {
+	return &DeviceStore{
+		configs:      map[uuid.UUID]devopsmysql.DeviceConfig{},
+		roles:        map[string]devopsmysql.DeviceRole{},
+		heartbeats:   map[uuid.UUID]time.Time{},
+		alertMgrs:    map[uuid.UUID]devopsmysql.AlertMgrAddress{},
+		secrets:      map[uuid.UUID]devopsmysql.DeviceSecret{},
+		tokens:       map[uuid.UUID]devopsmysql.ApiToken{},
+		maintenances: map[uuid.UUID]devopsmysql.MaintenanceWindow{},
+	}
+}
+
+var errNotFound = xerrors.Errorf("cannot find any value")
+
+func containsId(ids []uuid.UUID, id uuid.UUID) bool {
+	for _, one := range ids {
+		if one == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (store *DeviceStore) QueryDeviceConfig(id uuid.UUID) (*devopsmysql.DeviceConfig, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.configs[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &info, nil
+}
+
+func (store *DeviceStore) InsertDeviceConfig(info devopsmysql.DeviceConfig) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	oldInfo, ok := store.configs[info.Id]
+	if !ok {
+		store.configs[info.Id] = info
+		return nil
+	}
+
+	if oldInfo.Maintaining || oldInfo.Decommissioned {
+		if oldInfo.ParentSpec == "" {
+			oldInfo.ParentSpec = info.ParentSpec
+		} else if !strings.Contains(oldInfo.ParentSpec, info.ParentSpec) {
+			oldInfo.ParentSpec = oldInfo.ParentSpec + "," + info.ParentSpec
+		}
+		info.ParentSpec = oldInfo.ParentSpec
+		info.CreateTime = oldInfo.CreateTime
+		info.Maintaining = oldInfo.Maintaining
+		info.Offline = oldInfo.Offline
+		info.Decommissioned = oldInfo.Decommissioned
+		store.configs[info.Id] = info
+		return nil
+	}
+
+	if oldInfo.ParentSpec == "" {
+		oldInfo.ParentSpec = info.ParentSpec
+	} else if !strings.Contains(oldInfo.ParentSpec, info.ParentSpec) {
+		oldInfo.ParentSpec = oldInfo.ParentSpec + "," + info.ParentSpec
+	} else {
+		return xerrors.Errorf("invalid operation without maintaining mode")
+	}
+
+	store.configs[info.Id] = oldInfo
+	return nil
+}
+
+func (store *DeviceStore) QueryDeviceConfigs() ([]devopsmysql.DeviceConfig, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceConfig{}
+	for _, info := range store.configs {
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func ownedBy(info devopsmysql.DeviceConfig, username string) bool {
+	return info.Owner == username || info.CurrentUser == username || info.Manager == username
+}
+
+func (store *DeviceStore) QueryDeviceConfigsByUser(username string) ([]devopsmysql.DeviceConfig, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceConfig{}
+	for _, info := range store.configs {
+		if ownedBy(info, username) {
+			infos = append(infos, info)
+		}
+	}
+	if len(infos) == 0 {
+		return nil, xerrors.Errorf("find no value")
+	}
+	return infos, nil
+}
+
+func hasParentSpec(info devopsmysql.DeviceConfig, parentSpec string) bool {
+	for _, spec := range strings.Split(info.ParentSpec, ",") {
+		if spec == parentSpec {
+			return true
+		}
+	}
+	return false
+}
+
+func matchFilter(info devopsmysql.DeviceConfig, filter devopsmysql.DeviceFilter) bool {
+	if filter.Username != "" && !ownedBy(info, filter.Username) {
+		return false
+	}
+	if filter.Role != "" && info.Role != filter.Role {
+		return false
+	}
+	if filter.SubRole != "" && info.SubRole != filter.SubRole {
+		return false
+	}
+	if filter.Owner != "" && info.Owner != filter.Owner {
+		return false
+	}
+	if filter.ParentSpec != "" && !hasParentSpec(info, filter.ParentSpec) {
+		return false
+	}
+	if filter.OsSpec != "" && info.OsSpec != filter.OsSpec {
+		return false
+	}
+	if filter.Maintaining != nil && info.Maintaining != *filter.Maintaining {
+		return false
+	}
+	if filter.Offline != nil && info.Offline != *filter.Offline {
+		return false
+	}
+	if !filter.IncludeDecommissioned && info.Decommissioned {
+		return false
+	}
+	return true
+}
+
+func compareBool(a, b bool) int {
+	if a == b {
+		return 0
+	}
+	if a {
+		return 1
+	}
+	return -1
+}
+
+func compareTime(a, b time.Time) int {
+	if a.Equal(b) {
+		return 0
+	}
+	if a.After(b) {
+		return 1
+	}
+	return -1
+}
+
+var deviceSortKeys = map[string]func(a, b devopsmysql.DeviceConfig) int{
+	"":             func(a, b devopsmysql.DeviceConfig) int { return compareTime(a.CreateTime, b.CreateTime) },
+	"create_time":  func(a, b devopsmysql.DeviceConfig) int { return compareTime(a.CreateTime, b.CreateTime) },
+	"modify_time":  func(a, b devopsmysql.DeviceConfig) int { return compareTime(a.ModifyTime, b.ModifyTime) },
+	"spec":         func(a, b devopsmysql.DeviceConfig) int { return strings.Compare(a.Spec, b.Spec) },
+	"role":         func(a, b devopsmysql.DeviceConfig) int { return strings.Compare(a.Role, b.Role) },
+	"sub_role":     func(a, b devopsmysql.DeviceConfig) int { return strings.Compare(a.SubRole, b.SubRole) },
+	"owner":        func(a, b devopsmysql.DeviceConfig) int { return strings.Compare(a.Owner, b.Owner) },
+	"current_user": func(a, b devopsmysql.DeviceConfig) int { return strings.Compare(a.CurrentUser, b.CurrentUser) },
+	"manager":      func(a, b devopsmysql.DeviceConfig) int { return strings.Compare(a.Manager, b.Manager) },
+	"os_spec":      func(a, b devopsmysql.DeviceConfig) int { return strings.Compare(a.OsSpec, b.OsSpec) },
+	"maintaining":  func(a, b devopsmysql.DeviceConfig) int { return compareBool(a.Maintaining, b.Maintaining) },
+	"offline":      func(a, b devopsmysql.DeviceConfig) int { return compareBool(a.Offline, b.Offline) },
+}
+
+func (store *DeviceStore) QueryDeviceConfigsByFilter(filter devopsmysql.DeviceFilter) ([]devopsmysql.DeviceConfig, int, error) {
+	compare, ok := deviceSortKeys[filter.SortBy]
+	if !ok {
+		return nil, 0, xerrors.Errorf("invalid sort key %v", filter.SortBy)
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceConfig{}
+	for _, info := range store.configs {
+		if matchFilter(info, filter) {
+			infos = append(infos, info)
+		}
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		c := compare(infos[i], infos[j])
+		if filter.SortDesc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return infos[i].Id.String() < infos[j].Id.String()
+	})
+
+	total := len(infos)
+	if filter.Limit > 0 {
+		start := filter.Offset
+		if start > total {
+			start = total
+		}
+		end := start + filter.Limit
+		if end > total {
+			end = total
+		}
+		infos = infos[start:end]
+	}
+
+	return infos, total, nil
+}
+
+func (store *DeviceStore) SetDeviceMaintaining(id uuid.UUID, maintaining bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.configs[id]
+	if !ok {
+		return errNotFound
+	}
+	info.Maintaining = maintaining
+	store.configs[id] = info
+	return nil
+}
+
+func (store *DeviceStore) SetDevicesMaintaining(ids []uuid.UUID, maintaining bool) (map[uuid.UUID]error, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	failures := map[uuid.UUID]error{}
+	for _, id := range ids {
+		if _, ok := store.configs[id]; !ok {
+			failures[id] = errNotFound
+		}
+	}
+	if len(failures) > 0 {
+		return failures, xerrors.Errorf("%v of %v devices failed, rolled back", len(failures), len(ids))
+	}
+
+	for _, id := range ids {
+		info := store.configs[id]
+		info.Maintaining = maintaining
+		info.ModifyTime = time.Now()
+		store.configs[id] = info
+	}
+
+	return failures, nil
+}
+
+func (store *DeviceStore) DecommissionDevice(id uuid.UUID, reason string, operator string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.configs[id]
+	if !ok {
+		return errNotFound
+	}
+	if info.Decommissioned {
+		return xerrors.Errorf("device %v is already decommissioned", id)
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	store.decommissions = append(store.decommissions, devopsmysql.DeviceDecommission{
+		Id:         uuid.New(),
+		DeviceId:   id,
+		Spec:       info.Spec,
+		Reason:     reason,
+		Operator:   operator,
+		Config:     string(b),
+		CreateTime: time.Now(),
+	})
+
+	info.Decommissioned = true
+	info.ModifyTime = time.Now()
+	store.configs[id] = info
+
+	return nil
+}
+
+func (store *DeviceStore) TransferDevice(id uuid.UUID, owner, currentUser, manager, operator string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.configs[id]
+	if !ok {
+		return errNotFound
+	}
+
+	store.ownerships = append(store.ownerships, devopsmysql.DeviceOwnership{
+		Id:          uuid.New(),
+		DeviceId:    id,
+		Owner:       owner,
+		CurrentUser: currentUser,
+		Manager:     manager,
+		PrevOwner:   info.Owner,
+		PrevUser:    info.CurrentUser,
+		PrevManager: info.Manager,
+		Operator:    operator,
+		CreateTime:  time.Now(),
+	})
+
+	info.Owner = owner
+	info.CurrentUser = currentUser
+	info.Manager = manager
+	info.ModifyTime = time.Now()
+	store.configs[id] = info
+
+	return nil
+}
+
+func (store *DeviceStore) QueryDeviceOwnerships(deviceId uuid.UUID) ([]devopsmysql.DeviceOwnership, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceOwnership{}
+	for i := len(store.ownerships) - 1; i >= 0; i-- {
+		if store.ownerships[i].DeviceId == deviceId {
+			infos = append(infos, store.ownerships[i])
+		}
+	}
+	return infos, nil
+}
+
+func (store *DeviceStore) InsertDeviceReport(info devopsmysql.DeviceReport) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if info.Id == uuid.Nil {
+		info.Id = uuid.New()
+	}
+	info.CreateTime = time.Now()
+	store.reports = append(store.reports, info)
+	return nil
+}
+
+func (store *DeviceStore) QueryDeviceReports(deviceId uuid.UUID, limit int) ([]devopsmysql.DeviceReport, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceReport{}
+	for i := len(store.reports) - 1; i >= 0 && (limit <= 0 || len(infos) < limit); i-- {
+		if store.reports[i].DeviceId == deviceId {
+			infos = append(infos, store.reports[i])
+		}
+	}
+	return infos, nil
+}
+
+func (store *DeviceStore) PruneDeviceReports(before time.Time) (int64, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	reports := []devopsmysql.DeviceReport{}
+	for _, info := range store.reports {
+		if !info.CreateTime.Before(before) {
+			reports = append(reports, info)
+		}
+	}
+	pruned := int64(len(store.reports) - len(reports))
+	store.reports = reports
+	return pruned, nil
+}
+
+func (store *DeviceStore) InsertDeviceDrift(info devopsmysql.DeviceDrift) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var latest *devopsmysql.DeviceDrift
+	for i := len(store.drifts) - 1; i >= 0; i-- {
+		if store.drifts[i].DeviceId == info.DeviceId && store.drifts[i].Item == info.Item {
+			latest = &store.drifts[i]
+			break
+		}
+	}
+
+	if info.Actual == info.Expected && latest == nil {
+		return false, nil
+	}
+	if latest != nil && latest.Actual == info.Actual && latest.Expected == info.Expected {
+		return false, nil
+	}
+
+	info.Id = uuid.New()
+	info.CreateTime = time.Now()
+	store.drifts = append(store.drifts, info)
+
+	return true, nil
+}
+
+func (store *DeviceStore) QueryDeviceDrifts(deviceIds []uuid.UUID) ([]devopsmysql.DeviceDrift, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceDrift{}
+	for i := len(store.drifts) - 1; i >= 0; i-- {
+		if deviceIds == nil || containsId(deviceIds, store.drifts[i].DeviceId) {
+			infos = append(infos, store.drifts[i])
+		}
+	}
+	return infos, nil
+}
+
+func (store *DeviceStore) UpdateDeviceHeartbeat(deviceId uuid.UUID, reportTime time.Time) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.heartbeats[deviceId] = reportTime
+	return nil
+}
+
+func (store *DeviceStore) QueryDeviceHeartbeats() (map[uuid.UUID]time.Time, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	heartbeats := map[uuid.UUID]time.Time{}
+	for id, reportTime := range store.heartbeats {
+		heartbeats[id] = reportTime
+	}
+	return heartbeats, nil
+}
+
+func (store *DeviceStore) SetDeviceOffline(id uuid.UUID, offline bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if info, ok := store.configs[id]; ok {
+		info.Offline = offline
+		store.configs[id] = info
+	}
+
+	store.statusChanges = append(store.statusChanges, devopsmysql.DeviceStatusChange{
+		Id:         uuid.New(),
+		DeviceId:   id,
+		Offline:    offline,
+		CreateTime: time.Now(),
+	})
+
+	return nil
+}
+
+func (store *DeviceStore) QueryDeviceStatusChanges(deviceId uuid.UUID, limit int) ([]devopsmysql.DeviceStatusChange, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceStatusChange{}
+	for i := len(store.statusChanges) - 1; i >= 0 && (limit <= 0 || len(infos) < limit); i-- {
+		if store.statusChanges[i].DeviceId == deviceId {
+			infos = append(infos, store.statusChanges[i])
+		}
+	}
+	return infos, nil
+}
+
+func (store *DeviceStore) ValidateRole(role string) (bool, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, ok := store.roles[role]
+	return ok, nil
+}
+
+func (store *DeviceStore) ValidateSubRole(role string, subRole string) (bool, error) {
+	info, err := store.QueryDeviceRole(role)
+	if err != nil {
+		return false, err
+	}
+	if info.SubRoles == "" {
+		return true, nil
+	}
+	for _, allowed := range strings.Split(info.SubRoles, ",") {
+		if allowed == subRole {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (store *DeviceStore) QueryDeviceRole(role string) (*devopsmysql.DeviceRole, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.roles[role]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &info, nil
+}
+
+func (store *DeviceStore) QueryDeviceRoles() ([]devopsmysql.DeviceRole, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.DeviceRole{}
+	for _, info := range store.roles {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].RoleName < infos[j].RoleName
+	})
+	return infos, nil
+}
+
+func (store *DeviceStore) InsertDeviceRole(role string, subRoles []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.roles[role]; ok {
+		return xerrors.Errorf("role %v already exists", role)
+	}
+
+	store.roles[role] = devopsmysql.DeviceRole{
+		Id:       uuid.New().String(),
+		RoleName: role,
+		SubRoles: strings.Join(subRoles, ","),
+	}
+	return nil
+}
+
+func (store *DeviceStore) UpdateDeviceRole(role string, subRoles []string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.roles[role]
+	if !ok {
+		return errNotFound
+	}
+
+	if len(subRoles) > 0 {
+		count := 0
+		for _, config := range store.configs {
+			if config.Role != role || config.Decommissioned {
+				continue
+			}
+			used := false
+			for _, subRole := range subRoles {
+				if config.SubRole == subRole {
+					used = true
+					break
+				}
+			}
+			if !used {
+				count++
+			}
+		}
+		if count > 0 {
+			return xerrors.Errorf("%v devices still use sub roles out of %v", count, subRoles)
+		}
+	}
+
+	info.SubRoles = strings.Join(subRoles, ",")
+	store.roles[role] = info
+	return nil
+}
+
+func (store *DeviceStore) DeleteDeviceRole(role string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.roles[role]; !ok {
+		return errNotFound
+	}
+
+	count := 0
+	for _, config := range store.configs {
+		if config.Role == role && !config.Decommissioned {
+			count++
+		}
+	}
+	if count > 0 {
+		return xerrors.Errorf("role %v is still used by %v devices", role, count)
+	}
+
+	delete(store.roles, role)
+	return nil
+}
+
+func (store *DeviceStore) QueryAlertMgrAddress(id uuid.UUID) (*devopsmysql.AlertMgrAddress, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.alertMgrs[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &info, nil
+}
+
+func (store *DeviceStore) QueryAlertMgrAddresses() ([]devopsmysql.AlertMgrAddress, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.AlertMgrAddress{}
+	for _, info := range store.alertMgrs {
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreateTime.Before(infos[j].CreateTime)
+	})
+	return infos, nil
+}
+
+func (store *DeviceStore) InsertAlertMgrAddress(info devopsmysql.AlertMgrAddress) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info.CreateTime = time.Now()
+	if oldInfo, ok := store.alertMgrs[info.Id]; ok {
+		info.CreateTime = oldInfo.CreateTime
+	}
+	info.ModifyTime = time.Now()
+	store.alertMgrs[info.Id] = info
+	return nil
+}
+
+func (store *DeviceStore) QueryDeviceSecret(deviceId uuid.UUID) (*devopsmysql.DeviceSecret, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.secrets[deviceId]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &info, nil
+}
+
+func (store *DeviceStore) UpdateDeviceSecret(deviceId uuid.UUID, secret string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.secrets[deviceId] = devopsmysql.DeviceSecret{
+		DeviceId:   deviceId,
+		Secret:     secret,
+		CreateTime: time.Now(),
+	}
+	return nil
+}
+
+func (store *DeviceStore) InsertApiToken(info devopsmysql.ApiToken) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.tokens[info.Id]; ok {
+		return xerrors.Errorf("token %v already exists", info.Id)
+	}
+	info.CreateTime = time.Now()
+	store.tokens[info.Id] = info
+	return nil
+}
+
+func (store *DeviceStore) QueryApiTokenByHash(hash string) (*devopsmysql.ApiToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, info := range store.tokens {
+		if info.TokenHash == hash {
+			return &info, nil
+		}
+	}
+	return nil, errNotFound
+}
+
+func (store *DeviceStore) QueryApiToken(id uuid.UUID) (*devopsmysql.ApiToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.tokens[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &info, nil
+}
+
+func (store *DeviceStore) QueryApiTokens(username string) ([]devopsmysql.ApiToken, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.ApiToken{}
+	for _, info := range store.tokens {
+		if username == "" || info.Username == username {
+			infos = append(infos, info)
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].CreateTime.After(infos[j].CreateTime)
+	})
+	return infos, nil
+}
+
+func (store *DeviceStore) RevokeApiToken(id uuid.UUID) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if info, ok := store.tokens[id]; ok {
+		info.Revoked = true
+		store.tokens[id] = info
+	}
+	return nil
+}
+
+func (store *DeviceStore) RevokeSuperApiTokens(username string) (int, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	count := 0
+	for id, info := range store.tokens {
+		if info.Username == username && info.SuperUser && !info.Revoked {
+			info.Revoked = true
+			store.tokens[id] = info
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (store *DeviceStore) InsertAuditLog(info devopsmysql.AuditLog) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info.Id = uuid.New()
+	info.CreateTime = time.Now()
+	store.auditLogs = append(store.auditLogs, info)
+	return nil
+}
+
+func (store *DeviceStore) QueryAuditLogs(filter devopsmysql.AuditFilter) ([]devopsmysql.AuditLog, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.AuditLog{}
+	for i := len(store.auditLogs) - 1; i >= 0; i-- {
+		info := store.auditLogs[i]
+		if filter.DeviceId != uuid.Nil && info.DeviceId != filter.DeviceId {
+			continue
+		}
+		if filter.Actor != "" && info.Actor != filter.Actor {
+			continue
+		}
+		if !filter.Start.IsZero() && info.CreateTime.Before(filter.Start) {
+			continue
+		}
+		if !filter.End.IsZero() && !info.CreateTime.Before(filter.End) {
+			continue
+		}
+		infos = append(infos, info)
+		if filter.Limit > 0 && len(infos) >= filter.Limit {
+			break
+		}
+	}
+	return infos, nil
+}
+
+func (store *DeviceStore) InsertMaintenanceWindow(info devopsmysql.MaintenanceWindow) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	if _, ok := store.maintenances[info.Id]; ok {
+		return xerrors.Errorf("maintenance window %v already exists", info.Id)
+	}
+
+	info.Status = devopsmysql.MaintenanceScheduled
+	info.CreateTime = time.Now()
+	info.ModifyTime = time.Now()
+	store.maintenances[info.Id] = info
+	store.maintenanceIds = append(store.maintenanceIds, info.Id)
+	return nil
+}
+
+func (store *DeviceStore) QueryMaintenanceWindow(id uuid.UUID) (*devopsmysql.MaintenanceWindow, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	info, ok := store.maintenances[id]
+	if !ok {
+		return nil, errNotFound
+	}
+	return &info, nil
+}
+
+func (store *DeviceStore) maintenanceWindows(match func(devopsmysql.MaintenanceWindow) bool) []devopsmysql.MaintenanceWindow {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	infos := []devopsmysql.MaintenanceWindow{}
+	for _, id := range store.maintenanceIds {
+		info := store.maintenances[id]
+		if match(info) {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+func (store *DeviceStore) QueryMaintenanceWindows(deviceIds []uuid.UUID) ([]devopsmysql.MaintenanceWindow, error) {
+	infos := store.maintenanceWindows(func(info devopsmysql.MaintenanceWindow) bool {
+		return deviceIds == nil || containsId(deviceIds, info.DeviceId)
+	})
+	sort.SliceStable(infos, func(i, j int) bool {
+		return infos[i].StartTime.After(infos[j].StartTime)
+	})
+	return infos, nil
+}
+
+func (store *DeviceStore) QueryDueMaintenanceWindows(now time.Time) ([]devopsmysql.MaintenanceWindow, error) {
+	return store.maintenanceWindows(func(info devopsmysql.MaintenanceWindow) bool {
+		return info.Status == devopsmysql.MaintenanceScheduled &&
+			!info.StartTime.After(now) && info.EndTime.After(now)
+	}), nil
+}
+
+func (store *DeviceStore) QueryExpiredMaintenanceWindows(now time.Time) ([]devopsmysql.MaintenanceWindow, error) {
+	return store.maintenanceWindows(func(info devopsmysql.MaintenanceWindow) bool {
+		return (info.Status == devopsmysql.MaintenanceScheduled || info.Status == devopsmysql.MaintenanceActive) &&
+			!info.EndTime.After(now)
+	}), nil
+}
+
+func (store *DeviceStore) CountActiveMaintenanceWindows(deviceId uuid.UUID, except uuid.UUID) (int, error) {
+	infos := store.maintenanceWindows(func(info devopsmysql.MaintenanceWindow) bool {
+		return info.DeviceId == deviceId && info.Status == devopsmysql.MaintenanceActive && info.Id != except
+	})
+	return len(infos), nil
+}
+
+func (store *DeviceStore) UpdateMaintenanceWindow(info devopsmysql.MaintenanceWindow) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	oldInfo, ok := store.maintenances[info.Id]
+	if !ok {
+		return nil
+	}
+	oldInfo.Status = info.Status
+	oldInfo.SilenceAddress = info.SilenceAddress
+	oldInfo.SilenceId = info.SilenceId
+	oldInfo.SetMaintaining = info.SetMaintaining
+	oldInfo.ModifyTime = time.Now()
+	store.maintenances[info.Id] = oldInfo
+	return nil
+}
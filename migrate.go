@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/xerrors"
+)
+
+func openMysqlCli(cctx *cli.Context) (*devopsmysql.MysqlCli, error) {
+	config, err := readDevopsConfig(cctx.String("config"))
+	if err != nil {
+		return nil, err
+	}
+
+	mysqlCli := devopsmysql.NewMysqlCli(config.MysqlCfg)
+	if mysqlCli == nil {
+		return nil, xerrors.Errorf("cannot create mysql client %v", config.MysqlCfg.Host)
+	}
+
+	return mysqlCli, nil
+}
+
+var migrateCmd = &cli.Command{
+	Name:  "migrate",
+	Usage: "Migrate the devops database schema",
+	Subcommands: []*cli.Command{
+		{
+			Name:  "up",
+			Usage: "Apply migrations up to a version, the latest by default",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "to",
+					Value: devopsmysql.LatestSchemaVersion(),
+				},
+			},
+			Action: func(cctx *cli.Context) error {
+				mysqlCli, err := openMysqlCli(cctx)
+				if err != nil {
+					return err
+				}
+				defer mysqlCli.Delete()
+
+				current, err := mysqlCli.SchemaVersion()
+				if err != nil {
+					return err
+				}
+				if cctx.Int("to") < current {
+					return xerrors.Errorf("schema is already at %v, use migrate down", current)
+				}
+
+				return mysqlCli.MigrateTo(cctx.Int("to"))
+			},
+		},
+		{
+			Name:  "down",
+			Usage: "Revert migrations down to a version, one step by default",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "to",
+					Value: -1,
+				},
+			},
+			Action: func(cctx *cli.Context) error {
+				mysqlCli, err := openMysqlCli(cctx)
+				if err != nil {
+					return err
+				}
+				defer mysqlCli.Delete()
+
+				current, err := mysqlCli.SchemaVersion()
+				if err != nil {
+					return err
+				}
+
+				version := cctx.Int("to")
+				if version < 0 {
+					version = current - 1
+				}
+				if version < 0 || current < version {
+					return xerrors.Errorf("cannot migrate down from %v to %v", current, version)
+				}
+
+				return mysqlCli.MigrateTo(version)
+			},
+		},
+		{
+			Name:  "status",
+			Usage: "Show applied and pending migrations",
+			Action: func(cctx *cli.Context) error {
+				mysqlCli, err := openMysqlCli(cctx)
+				if err != nil {
+					return err
+				}
+				defer mysqlCli.Delete()
+
+				applied, err := mysqlCli.AppliedMigrations()
+				if err != nil {
+					return err
+				}
+
+				applyTimes := map[int]string{}
+				for _, info := range applied {
+					applyTimes[info.Version] = info.ApplyTime.Format("2006-01-02 15:04:05")
+				}
+
+				for _, migration := range devopsmysql.Migrations() {
+					applyTime, ok := applyTimes[migration.Version]
+					if !ok {
+						applyTime = "pending"
+					}
+					fmt.Printf("%4v  %-20v  %v\n", migration.Version, applyTime, migration.Name)
+				}
+				return nil
+			},
+		},
+	},
+}
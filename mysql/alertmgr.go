@@ -0,0 +1,52 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+type AlertMgrAddress struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key"`
+	Address    string    `gorm:"column:address"`
+	Role       string    `gorm:"column:role"`
+	SubRole    string    `gorm:"column:sub_role"`
+	ParentSpec string    `gorm:"column:parent_spec"`
+	CreateTime time.Time `gorm:"column:create_time"`
+	ModifyTime time.Time `gorm:"column:modify_time"`
+}
+
+func (cli *MysqlCli) QueryAlertMgrAddress(id uuid.UUID) (*AlertMgrAddress, error) {
+	var info AlertMgrAddress
+	var count int
+
+	cli.db.Where("id = ?", id).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) QueryAlertMgrAddresses() ([]AlertMgrAddress, error) {
+	var infos []AlertMgrAddress
+	rc := cli.db.Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+func (cli *MysqlCli) InsertAlertMgrAddress(info AlertMgrAddress) error {
+	oldInfo, err := cli.QueryAlertMgrAddress(info.Id)
+	if err == nil && oldInfo != nil {
+		info.CreateTime = oldInfo.CreateTime
+		info.ModifyTime = time.Now()
+		return cli.db.Save(&info).Error
+	}
+
+	info.CreateTime = time.Now()
+	info.ModifyTime = time.Now()
+
+	return cli.db.Create(&info).Error
+}
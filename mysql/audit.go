@@ -0,0 +1,57 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type AuditLog struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key"`
+	Actor      string    `gorm:"column:actor"`
+	Action     string    `gorm:"column:action"`
+	DeviceId   uuid.UUID `gorm:"column:device_id"`
+	Diff       string    `gorm:"column:diff"`
+	SourceIp   string    `gorm:"column:source_ip"`
+	CreateTime time.Time `gorm:"column:create_time"`
+}
+
+type AuditFilter struct {
+	DeviceId uuid.UUID
+	Actor    string
+	Start    time.Time
+	End      time.Time
+	Limit    int
+}
+
+func (cli *MysqlCli) InsertAuditLog(info AuditLog) error {
+	info.Id = uuid.New()
+	info.CreateTime = time.Now()
+	return cli.db.Create(&info).Error
+}
+
+func (cli *MysqlCli) QueryAuditLogs(filter AuditFilter) ([]AuditLog, error) {
+	db := cli.db
+
+	if filter.DeviceId != uuid.Nil {
+		db = db.Where("device_id = ?", filter.DeviceId)
+	}
+	if filter.Actor != "" {
+		db = db.Where("actor = ?", filter.Actor)
+	}
+	if !filter.Start.IsZero() {
+		db = db.Where("create_time >= ?", filter.Start)
+	}
+	if !filter.End.IsZero() {
+		db = db.Where("create_time < ?", filter.End)
+	}
+	if filter.Limit > 0 {
+		db = db.Limit(filter.Limit)
+	}
+
+	var infos []AuditLog
+	rc := db.Order("create_time desc").Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
@@ -0,0 +1,70 @@
+package devopsmysql
+
+import (
+	"encoding/json"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+type DeviceDecommission struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key"`
+	DeviceId   uuid.UUID `gorm:"column:device_id"`
+	Spec       string    `gorm:"column:spec"`
+	Reason     string    `gorm:"column:reason"`
+	Operator   string    `gorm:"column:operator"`
+	Config     string    `gorm:"column:config"`
+	CreateTime time.Time `gorm:"column:create_time"`
+}
+
+func (cli *MysqlCli) DecommissionDevice(id uuid.UUID, reason string, operator string) error {
+	info, err := cli.QueryDeviceConfig(id)
+	if err != nil {
+		return err
+	}
+
+	if info.Decommissioned {
+		return xerrors.Errorf("device %v is already decommissioned", id)
+	}
+
+	b, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	tx := cli.db.Begin()
+
+	rc := tx.Create(&DeviceDecommission{
+		Id:         uuid.New(),
+		DeviceId:   id,
+		Spec:       info.Spec,
+		Reason:     reason,
+		Operator:   operator,
+		Config:     string(b),
+		CreateTime: time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	rc = tx.Model(&DeviceConfig{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"decommissioned": true,
+		"modify_time":    time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryDeviceDecommissions(deviceId uuid.UUID) ([]DeviceDecommission, error) {
+	var infos []DeviceDecommission
+	rc := cli.db.Where("device_id = ?", deviceId).Order("create_time desc").Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
@@ -0,0 +1,106 @@
+package devopsmysql
+
+import (
+	"fmt"
+	"golang.org/x/xerrors"
+)
+
+type DeviceFilter struct {
+	Username              string
+	Role                  string
+	SubRole               string
+	Owner                 string
+	ParentSpec            string
+	OsSpec                string
+	Maintaining           *bool
+	Offline               *bool
+	IncludeDecommissioned bool
+	SortBy                string
+	SortDesc              bool
+	Offset                int
+	Limit                 int
+}
+
+var deviceSortColumns = map[string]string{
+	"":             "create_time",
+	"create_time":  "create_time",
+	"modify_time":  "modify_time",
+	"spec":         "spec",
+	"role":         "role",
+	"sub_role":     "sub_role",
+	"owner":        "owner",
+	"current_user": "current_user",
+	"manager":      "manager",
+	"os_spec":      "os_spec",
+	"maintaining":  "maintaining",
+	"offline":      "offline",
+}
+
+// ValidDeviceSortKey tells whether the device list can be sorted by key, an
+// empty key sorts by create time.
+func ValidDeviceSortKey(key string) bool {
+	_, ok := deviceSortColumns[key]
+	return ok
+}
+
+func (cli *MysqlCli) QueryDeviceConfigsByFilter(filter DeviceFilter) ([]DeviceConfig, int, error) {
+	column, ok := deviceSortColumns[filter.SortBy]
+	if !ok {
+		return nil, 0, xerrors.Errorf("invalid sort key %v", filter.SortBy)
+	}
+
+	db := cli.db.Model(&DeviceConfig{})
+
+	if filter.Username != "" {
+		db = db.Where("`owner` = ? or `current_user` = ? or `manager` = ?",
+			filter.Username, filter.Username, filter.Username)
+	}
+	if filter.Role != "" {
+		db = db.Where("role = ?", filter.Role)
+	}
+	if filter.SubRole != "" {
+		db = db.Where("sub_role = ?", filter.SubRole)
+	}
+	if filter.Owner != "" {
+		db = db.Where("`owner` = ?", filter.Owner)
+	}
+	if filter.ParentSpec != "" {
+		db = db.Where("find_in_set(?, parent_spec) > 0", filter.ParentSpec)
+	}
+	if filter.OsSpec != "" {
+		db = db.Where("os_spec = ?", filter.OsSpec)
+	}
+	if filter.Maintaining != nil {
+		db = db.Where("maintaining = ?", *filter.Maintaining)
+	}
+	if filter.Offline != nil {
+		db = db.Where("offline = ?", *filter.Offline)
+	}
+	if !filter.IncludeDecommissioned {
+		db = db.Where("decommissioned = ?", false)
+	}
+
+	total := 0
+	rc := db.Count(&total)
+	if rc.Error != nil {
+		return nil, 0, rc.Error
+	}
+
+	order := fmt.Sprintf("`%v`", column)
+	if filter.SortDesc {
+		order = fmt.Sprintf("%v desc", order)
+	}
+	db = db.Order(order).Order("id")
+
+	if filter.Limit > 0 {
+		db = db.Offset(filter.Offset).Limit(filter.Limit)
+	}
+
+	var infos []DeviceConfig
+	rc = db.Find(&infos)
+	if rc.Error != nil {
+		return nil, 0, rc.Error
+	}
+
+	return infos, total, nil
+}
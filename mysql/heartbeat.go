@@ -0,0 +1,72 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type DeviceHeartbeat struct {
+	DeviceId   uuid.UUID `gorm:"column:device_id;primary_key"`
+	ReportTime time.Time `gorm:"column:report_time"`
+}
+
+type DeviceStatusChange struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key"`
+	DeviceId   uuid.UUID `gorm:"column:device_id"`
+	Offline    bool      `gorm:"column:offline"`
+	CreateTime time.Time `gorm:"column:create_time"`
+}
+
+func (cli *MysqlCli) UpdateDeviceHeartbeat(deviceId uuid.UUID, reportTime time.Time) error {
+	return cli.db.Save(&DeviceHeartbeat{
+		DeviceId:   deviceId,
+		ReportTime: reportTime,
+	}).Error
+}
+
+func (cli *MysqlCli) QueryDeviceHeartbeats() (map[uuid.UUID]time.Time, error) {
+	var infos []DeviceHeartbeat
+	rc := cli.db.Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+
+	heartbeats := map[uuid.UUID]time.Time{}
+	for _, info := range infos {
+		heartbeats[info.DeviceId] = info.ReportTime
+	}
+
+	return heartbeats, nil
+}
+
+func (cli *MysqlCli) SetDeviceOffline(id uuid.UUID, offline bool) error {
+	tx := cli.db.Begin()
+
+	rc := tx.Model(&DeviceConfig{}).Where("id = ?", id).Update("offline", offline)
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	rc = tx.Create(&DeviceStatusChange{
+		Id:         uuid.New(),
+		DeviceId:   id,
+		Offline:    offline,
+		CreateTime: time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryDeviceStatusChanges(deviceId uuid.UUID, limit int) ([]DeviceStatusChange, error) {
+	var infos []DeviceStatusChange
+	rc := cli.db.Where("device_id = ?", deviceId).Order("create_time desc").Limit(limit).Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
@@ -0,0 +1,100 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+const (
+	MaintenanceScheduled = "scheduled"
+	MaintenanceActive    = "active"
+	MaintenanceFinished  = "finished"
+	MaintenanceCancelled = "cancelled"
+)
+
+type MaintenanceWindow struct {
+	Id             uuid.UUID `gorm:"column:id;primary_key"`
+	DeviceId       uuid.UUID `gorm:"column:device_id"`
+	StartTime      time.Time `gorm:"column:start_time"`
+	EndTime        time.Time `gorm:"column:end_time"`
+	Reason         string    `gorm:"column:reason"`
+	Operator       string    `gorm:"column:operator"`
+	Status         string    `gorm:"column:status"`
+	SilenceAddress string    `gorm:"column:silence_address"`
+	SilenceId      string    `gorm:"column:silence_id"`
+	SetMaintaining bool      `gorm:"column:set_maintaining"`
+	CreateTime     time.Time `gorm:"column:create_time"`
+	ModifyTime     time.Time `gorm:"column:modify_time"`
+}
+
+func (cli *MysqlCli) InsertMaintenanceWindow(info MaintenanceWindow) error {
+	info.Status = MaintenanceScheduled
+	info.CreateTime = time.Now()
+	info.ModifyTime = time.Now()
+	return cli.db.Create(&info).Error
+}
+
+func (cli *MysqlCli) QueryMaintenanceWindow(id uuid.UUID) (*MaintenanceWindow, error) {
+	var info MaintenanceWindow
+	var count int
+
+	cli.db.Where("id = ?", id).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) QueryMaintenanceWindows(deviceIds []uuid.UUID) ([]MaintenanceWindow, error) {
+	var infos []MaintenanceWindow
+	db := cli.db
+	if deviceIds != nil {
+		db = db.Where("device_id in (?)", deviceIds)
+	}
+	rc := db.Order("start_time desc").Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+func (cli *MysqlCli) QueryDueMaintenanceWindows(now time.Time) ([]MaintenanceWindow, error) {
+	var infos []MaintenanceWindow
+	rc := cli.db.Where("status = ? and start_time <= ? and end_time > ?", MaintenanceScheduled, now, now).Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+// QueryExpiredMaintenanceWindows also returns scheduled windows which ended
+// before the scheduler got a chance to activate them.
+func (cli *MysqlCli) QueryExpiredMaintenanceWindows(now time.Time) ([]MaintenanceWindow, error) {
+	var infos []MaintenanceWindow
+	rc := cli.db.Where("status in (?) and end_time <= ?",
+		[]string{MaintenanceScheduled, MaintenanceActive}, now).Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+func (cli *MysqlCli) CountActiveMaintenanceWindows(deviceId uuid.UUID, except uuid.UUID) (int, error) {
+	count := 0
+	rc := cli.db.Model(&MaintenanceWindow{}).
+		Where("device_id = ? and status = ? and id <> ?", deviceId, MaintenanceActive, except).
+		Count(&count)
+	return count, rc.Error
+}
+
+func (cli *MysqlCli) UpdateMaintenanceWindow(info MaintenanceWindow) error {
+	return cli.db.Model(&MaintenanceWindow{}).Where("id = ?", info.Id).Updates(map[string]interface{}{
+		"status":          info.Status,
+		"silence_address": info.SilenceAddress,
+		"silence_id":      info.SilenceId,
+		"set_maintaining": info.SetMaintaining,
+		"modify_time":     time.Now(),
+	}).Error
+}
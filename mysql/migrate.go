@@ -0,0 +1,409 @@
+package devopsmysql
+
+import (
+	"fmt"
+	log "github.com/EntropyPool/entropy-logger"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+// Migration is one step of the devops schema, Down must undo exactly what Up
+// did. Never edit a released migration, append a new one instead. A step
+// with Table and Column adds that column to a table which may predate
+// versioning: Up is skipped when the column is there and Down when it is not.
+type Migration struct {
+	Version int
+	Name    string
+	Up      []string
+	Down    []string
+	Table   string
+	Column  string
+}
+
+func addColumnMigration(version int, name string, table string, column string, definition string) Migration {
+	return Migration{
+		Version: version,
+		Name:    name,
+		Up:      []string{fmt.Sprintf("alter table `%v` add column `%v` %v", table, column, definition)},
+		Down:    []string{fmt.Sprintf("alter table `%v` drop column `%v`", table, column)},
+		Table:   table,
+		Column:  column,
+	}
+}
+
+type SchemaVersion struct {
+	Version   int       `gorm:"column:version;primary_key;auto_increment:false"`
+	Name      string    `gorm:"column:name"`
+	ApplyTime time.Time `gorm:"column:apply_time"`
+}
+
+// The first migrations use "if not exists" so that databases created by hand
+// before versioning adopt the schema without losing data. Such tables keep
+// their old columns, new columns of them go into add-column steps.
+var migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create device tables",
+		Up: []string{
+			"create table if not exists `device_config` (" +
+				"`id` varchar(36) not null," +
+				"`spec` varchar(255) not null default ''," +
+				"`parent_spec` varchar(1024) not null default ''," +
+				"`role` varchar(64) not null default ''," +
+				"`sub_role` varchar(64) not null default ''," +
+				"`owner` varchar(128) not null default ''," +
+				"`current_user` varchar(128) not null default ''," +
+				"`manager` varchar(128) not null default ''," +
+				"`nvme_count` int not null default 0," +
+				"`nvme_desc` text," +
+				"`gpu_count` int not null default 0," +
+				"`gpu_desc` text," +
+				"`memory_count` int not null default 0," +
+				"`memory_size` bigint unsigned not null default 0," +
+				"`memory_desc` text," +
+				"`cpu_count` int not null default 0," +
+				"`cpu_desc` text," +
+				"`hdd_count` int not null default 0," +
+				"`hdd_desc` text," +
+				"`ethernet_count` int not null default 0," +
+				"`ethernet_desc` text," +
+				"`os_spec` varchar(255) not null default ''," +
+				"`maintaining` tinyint(1) not null default 0," +
+				"`offline` tinyint(1) not null default 0," +
+				"`create_time` datetime," +
+				"`modify_time` datetime," +
+				"primary key (`id`))",
+			"create table if not exists `device_role` (" +
+				"`id` varchar(36) not null," +
+				"`role_name` varchar(64) not null," +
+				"primary key (`id`)," +
+				"unique key `uk_device_role_role_name` (`role_name`))",
+		},
+		Down: []string{
+			"drop table if exists `device_role`",
+			"drop table if exists `device_config`",
+		},
+	},
+	{
+		Version: 2,
+		Name:    "create report and heartbeat tables",
+		Up: []string{
+			"create table if not exists `device_report` (" +
+				"`id` varchar(36) not null," +
+				"`device_id` varchar(36) not null," +
+				"`nvme_count` int not null default 0," +
+				"`gpu_count` int not null default 0," +
+				"`memory_count` int not null default 0," +
+				"`memory_size` bigint unsigned not null default 0," +
+				"`hdd_count` int not null default 0," +
+				"`local_addr` varchar(255) not null default ''," +
+				"`public_addr` varchar(255) not null default ''," +
+				"`create_time` datetime," +
+				"primary key (`id`))",
+			"create table if not exists `device_drift` (" +
+				"`id` varchar(36) not null," +
+				"`device_id` varchar(36) not null," +
+				"`item` varchar(64) not null default ''," +
+				"`expected` int not null default 0," +
+				"`actual` int not null default 0," +
+				"`create_time` datetime," +
+				"primary key (`id`))",
+			"create table if not exists `device_heartbeat` (" +
+				"`device_id` varchar(36) not null," +
+				"`report_time` datetime," +
+				"primary key (`device_id`))",
+			"create table if not exists `device_status_change` (" +
+				"`id` varchar(36) not null," +
+				"`device_id` varchar(36) not null," +
+				"`offline` tinyint(1) not null default 0," +
+				"`create_time` datetime," +
+				"primary key (`id`))",
+		},
+		Down: []string{
+			"drop table if exists `device_status_change`",
+			"drop table if exists `device_heartbeat`",
+			"drop table if exists `device_drift`",
+			"drop table if exists `device_report`",
+		},
+	},
+	{
+		Version: 3,
+		Name:    "create lifecycle and alertmanager tables",
+		Up: []string{
+			"create table if not exists `device_decommission` (" +
+				"`id` varchar(36) not null," +
+				"`device_id` varchar(36) not null," +
+				"`spec` varchar(255) not null default ''," +
+				"`reason` varchar(1024) not null default ''," +
+				"`operator` varchar(128) not null default ''," +
+				"`config` text," +
+				"`create_time` datetime," +
+				"primary key (`id`))",
+			"create table if not exists `device_ownership` (" +
+				"`id` varchar(36) not null," +
+				"`device_id` varchar(36) not null," +
+				"`owner` varchar(128) not null default ''," +
+				"`current_user` varchar(128) not null default ''," +
+				"`manager` varchar(128) not null default ''," +
+				"`prev_owner` varchar(128) not null default ''," +
+				"`prev_user` varchar(128) not null default ''," +
+				"`prev_manager` varchar(128) not null default ''," +
+				"`operator` varchar(128) not null default ''," +
+				"`create_time` datetime," +
+				"primary key (`id`))",
+			"create table if not exists `device_secret` (" +
+				"`device_id` varchar(36) not null," +
+				"`secret` varchar(255) not null," +
+				"`create_time` datetime," +
+				"primary key (`device_id`))",
+			"create table if not exists `alert_mgr_address` (" +
+				"`id` varchar(36) not null," +
+				"`address` varchar(255) not null default ''," +
+				"`role` varchar(64) not null default ''," +
+				"`sub_role` varchar(64) not null default ''," +
+				"`parent_spec` varchar(1024) not null default ''," +
+				"`create_time` datetime," +
+				"`modify_time` datetime," +
+				"primary key (`id`))",
+		},
+		Down: []string{
+			"drop table if exists `alert_mgr_address`",
+			"drop table if exists `device_secret`",
+			"drop table if exists `device_ownership`",
+			"drop table if exists `device_decommission`",
+		},
+	},
+	{
+		Version: 4,
+		Name:    "create token, audit and maintenance tables",
+		Up: []string{
+			"create table if not exists `api_token` (" +
+				"`id` varchar(36) not null," +
+				"`name` varchar(128) not null default ''," +
+				"`token_hash` varchar(64) not null," +
+				"`username` varchar(128) not null default ''," +
+				"`super_user` tinyint(1) not null default 0," +
+				"`scopes` varchar(255) not null default ''," +
+				"`revoked` tinyint(1) not null default 0," +
+				"`expire_time` datetime," +
+				"`create_time` datetime," +
+				"primary key (`id`))",
+			"create table if not exists `audit_log` (" +
+				"`id` varchar(36) not null," +
+				"`actor` varchar(128) not null default ''," +
+				"`action` varchar(64) not null default ''," +
+				"`device_id` varchar(36) not null default ''," +
+				"`diff` text," +
+				"`source_ip` varchar(64) not null default ''," +
+				"`create_time` datetime," +
+				"primary key (`id`))",
+			"create table if not exists `maintenance_window` (" +
+				"`id` varchar(36) not null," +
+				"`device_id` varchar(36) not null," +
+				"`start_time` datetime," +
+				"`end_time` datetime," +
+				"`reason` varchar(1024) not null default ''," +
+				"`operator` varchar(128) not null default ''," +
+				"`status` varchar(32) not null default ''," +
+				"`silence_address` varchar(255) not null default ''," +
+				"`silence_id` varchar(64) not null default ''," +
+				"`create_time` datetime," +
+				"`modify_time` datetime," +
+				"primary key (`id`))",
+		},
+		Down: []string{
+			"drop table if exists `maintenance_window`",
+			"drop table if exists `audit_log`",
+			"drop table if exists `api_token`",
+		},
+	},
+	{
+		Version: 5,
+		Name:    "add device lookup indexes",
+		Up: []string{
+			"create index `idx_device_config_owner` on `device_config` (`owner`)",
+			"create index `idx_device_config_current_user` on `device_config` (`current_user`)",
+			"create index `idx_device_config_manager` on `device_config` (`manager`)",
+			"create index `idx_device_config_spec` on `device_config` (`spec`)",
+			"create index `idx_device_report_device_id` on `device_report` (`device_id`, `create_time`)",
+			"create index `idx_device_drift_device_id` on `device_drift` (`device_id`, `create_time`)",
+			"create index `idx_device_status_change_device_id` on `device_status_change` (`device_id`, `create_time`)",
+			"create index `idx_device_decommission_device_id` on `device_decommission` (`device_id`)",
+			"create index `idx_device_ownership_device_id` on `device_ownership` (`device_id`, `create_time`)",
+			"create unique index `uk_api_token_token_hash` on `api_token` (`token_hash`)",
+			"create index `idx_audit_log_device_id` on `audit_log` (`device_id`, `create_time`)",
+			"create index `idx_audit_log_actor` on `audit_log` (`actor`, `create_time`)",
+			"create index `idx_maintenance_window_device_id` on `maintenance_window` (`device_id`)",
+			"create index `idx_maintenance_window_status` on `maintenance_window` (`status`, `start_time`)",
+		},
+		Down: []string{
+			"drop index `idx_maintenance_window_status` on `maintenance_window`",
+			"drop index `idx_maintenance_window_device_id` on `maintenance_window`",
+			"drop index `idx_audit_log_actor` on `audit_log`",
+			"drop index `idx_audit_log_device_id` on `audit_log`",
+			"drop index `uk_api_token_token_hash` on `api_token`",
+			"drop index `idx_device_ownership_device_id` on `device_ownership`",
+			"drop index `idx_device_decommission_device_id` on `device_decommission`",
+			"drop index `idx_device_status_change_device_id` on `device_status_change`",
+			"drop index `idx_device_drift_device_id` on `device_drift`",
+			"drop index `idx_device_report_device_id` on `device_report`",
+			"drop index `idx_device_config_spec` on `device_config`",
+			"drop index `idx_device_config_manager` on `device_config`",
+			"drop index `idx_device_config_current_user` on `device_config`",
+			"drop index `idx_device_config_owner` on `device_config`",
+		},
+	},
+	addColumnMigration(6, "track maintaining flag set by maintenance windows",
+		"maintenance_window", "set_maintaining", "tinyint(1) not null default 0"),
+	addColumnMigration(7, "add device decommissioned flag",
+		"device_config", "decommissioned", "tinyint(1) not null default 0"),
+	addColumnMigration(8, "add device role sub roles",
+		"device_role", "sub_roles", "varchar(1024) not null default ''"),
+	{
+		Version: 9,
+		Name:    "add device report retention index",
+		Up: []string{
+			"create index `idx_device_report_create_time` on `device_report` (`create_time`)",
+		},
+		Down: []string{
+			"drop index `idx_device_report_create_time` on `device_report`",
+		},
+	},
+}
+
+func Migrations() []Migration {
+	return migrations
+}
+
+func LatestSchemaVersion() int {
+	return migrations[len(migrations)-1].Version
+}
+
+func (cli *MysqlCli) AppliedMigrations() ([]SchemaVersion, error) {
+	var infos []SchemaVersion
+	if !cli.db.HasTable(&SchemaVersion{}) {
+		return infos, nil
+	}
+	rc := cli.db.Order("version").Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+// SchemaVersion is 0 for a database never migrated.
+func (cli *MysqlCli) SchemaVersion() (int, error) {
+	infos, err := cli.AppliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(infos) == 0 {
+		return 0, nil
+	}
+	return infos[len(infos)-1].Version, nil
+}
+
+// migrationStore is the part of the database MigrateTo works on.
+type migrationStore interface {
+	execMigration(stmt string) error
+	hasColumn(table string, column string) bool
+	AppliedMigrations() ([]SchemaVersion, error)
+	recordMigration(info SchemaVersion) error
+	removeMigration(version int) error
+}
+
+func (cli *MysqlCli) execMigration(stmt string) error {
+	return cli.db.Exec(stmt).Error
+}
+
+func (cli *MysqlCli) hasColumn(table string, column string) bool {
+	return cli.db.Dialect().HasColumn(table, column)
+}
+
+func (cli *MysqlCli) recordMigration(info SchemaVersion) error {
+	return cli.db.Create(&info).Error
+}
+
+func (cli *MysqlCli) removeMigration(version int) error {
+	return cli.db.Where("version = ?", version).Delete(&SchemaVersion{}).Error
+}
+
+// MigrateTo applies Up or Down steps until the schema reaches version. MySQL
+// commits DDL implicitly, a failed step leaves the statements before it in
+// place and the version unchanged, fix the cause and run again.
+func (cli *MysqlCli) MigrateTo(version int) error {
+	if version < 0 || version > LatestSchemaVersion() {
+		return xerrors.Errorf("invalid schema version %v, latest is %v", version, LatestSchemaVersion())
+	}
+
+	err := cli.execMigration("create table if not exists `schema_version` (" +
+		"`version` int not null," +
+		"`name` varchar(255) not null default ''," +
+		"`apply_time` datetime," +
+		"primary key (`version`))")
+	if err != nil {
+		return xerrors.Errorf("cannot create schema version table: %v", err)
+	}
+
+	return migrate(cli, migrations, version)
+}
+
+func migrate(store migrationStore, migrations []Migration, version int) error {
+	infos, err := store.AppliedMigrations()
+	if err != nil {
+		return err
+	}
+	current := 0
+	if len(infos) > 0 {
+		current = infos[len(infos)-1].Version
+	}
+
+	for _, migration := range migrations {
+		if migration.Version <= current || version < migration.Version {
+			continue
+		}
+		log.Infof(log.Fields{}, "migrate up to %v: %v", migration.Version, migration.Name)
+		if migration.Column != "" && store.hasColumn(migration.Table, migration.Column) {
+			log.Infof(log.Fields{}, "column %v.%v exists, skip", migration.Table, migration.Column)
+		} else {
+			for _, stmt := range migration.Up {
+				err = store.execMigration(stmt)
+				if err != nil {
+					return xerrors.Errorf("cannot migrate up to %v: %v", migration.Version, err)
+				}
+			}
+		}
+		err = store.recordMigration(SchemaVersion{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			ApplyTime: time.Now(),
+		})
+		if err != nil {
+			return xerrors.Errorf("cannot record schema version %v: %v", migration.Version, err)
+		}
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		if current < migration.Version || migration.Version <= version {
+			continue
+		}
+		log.Infof(log.Fields{}, "migrate down from %v: %v", migration.Version, migration.Name)
+		if migration.Column != "" && !store.hasColumn(migration.Table, migration.Column) {
+			log.Infof(log.Fields{}, "column %v.%v is gone, skip", migration.Table, migration.Column)
+		} else {
+			for _, stmt := range migration.Down {
+				err = store.execMigration(stmt)
+				if err != nil {
+					return xerrors.Errorf("cannot migrate down from %v: %v", migration.Version, err)
+				}
+			}
+		}
+		err = store.removeMigration(migration.Version)
+		if err != nil {
+			return xerrors.Errorf("cannot remove schema version %v: %v", migration.Version, err)
+		}
+	}
+
+	return nil
+}
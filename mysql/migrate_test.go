@@ -0,0 +1,234 @@
+package devopsmysql
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for i, migration := range Migrations() {
+		if migration.Version != i+1 {
+			t.Fatalf("expect version %v, got %v", i+1, migration.Version)
+		}
+		if migration.Name == "" || len(migration.Up) == 0 {
+			t.Fatalf("empty migration %v", migration.Version)
+		}
+		if len(migration.Up) != len(migration.Down) {
+			t.Fatalf("migration %v has %v up but %v down statements",
+				migration.Version, len(migration.Up), len(migration.Down))
+		}
+	}
+	if LatestSchemaVersion() != len(Migrations()) {
+		t.Fatalf("unexpected latest version %v", LatestSchemaVersion())
+	}
+}
+
+func TestMigrationsCoverTables(t *testing.T) {
+	created := map[string]bool{}
+	for _, migration := range Migrations() {
+		for _, stmt := range migration.Up {
+			if strings.HasPrefix(stmt, "create table if not exists `") {
+				name := strings.TrimPrefix(stmt, "create table if not exists `")
+				created[name[:strings.Index(name, "`")]] = true
+			}
+		}
+	}
+
+	for _, table := range []string{
+		"device_config", "device_role", "device_report", "device_drift",
+		"device_heartbeat", "device_status_change", "device_decommission",
+		"device_ownership", "device_secret", "alert_mgr_address",
+		"api_token", "audit_log", "maintenance_window",
+	} {
+		if !created[table] {
+			t.Fatalf("table %v is not created by any migration", table)
+		}
+	}
+}
+
+// fakeSchema keeps the tables and columns the migration statements touch.
+type fakeSchema struct {
+	tables  map[string]map[string]bool
+	applied []SchemaVersion
+}
+
+var (
+	createTableStmt = regexp.MustCompile("^create table if not exists `(\\w+)` \\((.*)\\)$")
+	addColumnStmt   = regexp.MustCompile("^alter table `(\\w+)` add column `(\\w+)`")
+	dropColumnStmt  = regexp.MustCompile("^alter table `(\\w+)` drop column `(\\w+)`$")
+	dropTableStmt   = regexp.MustCompile("^drop table if exists `(\\w+)`$")
+	indexStmt       = regexp.MustCompile("^(create (unique )?index `\\w+`|drop index `\\w+`) on `(\\w+)`")
+)
+
+func newFakeSchema(stmts ...string) *fakeSchema {
+	schema := &fakeSchema{tables: map[string]map[string]bool{}}
+	for _, stmt := range stmts {
+		err := schema.execMigration(stmt)
+		if err != nil {
+			panic(err)
+		}
+	}
+	return schema
+}
+
+func (schema *fakeSchema) execMigration(stmt string) error {
+	if m := createTableStmt.FindStringSubmatch(stmt); m != nil {
+		if _, ok := schema.tables[m[1]]; ok {
+			return nil
+		}
+		columns := map[string]bool{}
+		for _, def := range strings.Split(m[2], ",") {
+			if strings.HasPrefix(def, "`") {
+				columns[strings.Split(def, "`")[1]] = true
+			}
+		}
+		schema.tables[m[1]] = columns
+		return nil
+	}
+	if m := addColumnStmt.FindStringSubmatch(stmt); m != nil {
+		columns, ok := schema.tables[m[1]]
+		if !ok || columns[m[2]] {
+			return fmt.Errorf("cannot add column %v.%v", m[1], m[2])
+		}
+		columns[m[2]] = true
+		return nil
+	}
+	if m := dropColumnStmt.FindStringSubmatch(stmt); m != nil {
+		if !schema.hasColumn(m[1], m[2]) {
+			return fmt.Errorf("cannot drop column %v.%v", m[1], m[2])
+		}
+		delete(schema.tables[m[1]], m[2])
+		return nil
+	}
+	if m := dropTableStmt.FindStringSubmatch(stmt); m != nil {
+		delete(schema.tables, m[1])
+		return nil
+	}
+	if m := indexStmt.FindStringSubmatch(stmt); m != nil {
+		if _, ok := schema.tables[m[3]]; !ok {
+			return fmt.Errorf("no table %v", m[3])
+		}
+		return nil
+	}
+	return fmt.Errorf("unexpected statement %v", stmt)
+}
+
+func (schema *fakeSchema) hasColumn(table string, column string) bool {
+	return schema.tables[table][column]
+}
+
+func (schema *fakeSchema) AppliedMigrations() ([]SchemaVersion, error) {
+	return schema.applied, nil
+}
+
+func (schema *fakeSchema) recordMigration(info SchemaVersion) error {
+	schema.applied = append(schema.applied, info)
+	return nil
+}
+
+func (schema *fakeSchema) removeMigration(version int) error {
+	for i, info := range schema.applied {
+		if info.Version == version {
+			schema.applied = append(schema.applied[:i], schema.applied[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("version %v is not applied", version)
+}
+
+// baselineSchema is the hand made schema deployed before versioning.
+var baselineSchema = []string{
+	"create table if not exists `device_config` (" +
+		"`id` varchar(36) not null," +
+		"`spec` varchar(255) not null default ''," +
+		"`parent_spec` varchar(1024) not null default ''," +
+		"`role` varchar(64) not null default ''," +
+		"`sub_role` varchar(64) not null default ''," +
+		"`owner` varchar(128) not null default ''," +
+		"`current_user` varchar(128) not null default ''," +
+		"`manager` varchar(128) not null default ''," +
+		"`nvme_count` int not null default 0," +
+		"`nvme_desc` text," +
+		"`gpu_count` int not null default 0," +
+		"`gpu_desc` text," +
+		"`memory_count` int not null default 0," +
+		"`memory_size` bigint unsigned not null default 0," +
+		"`memory_desc` text," +
+		"`cpu_count` int not null default 0," +
+		"`cpu_desc` text," +
+		"`hdd_count` int not null default 0," +
+		"`hdd_desc` text," +
+		"`ethernet_count` int not null default 0," +
+		"`ethernet_desc` text," +
+		"`os_spec` varchar(255) not null default ''," +
+		"`maintaining` tinyint(1) not null default 0," +
+		"`offline` tinyint(1) not null default 0," +
+		"`create_time` datetime," +
+		"`modify_time` datetime," +
+		"primary key (`id`))",
+	"create table if not exists `device_role` (" +
+		"`id` varchar(36) not null," +
+		"`role_name` varchar(64) not null," +
+		"primary key (`id`))",
+}
+
+func TestMigrateFromBaseline(t *testing.T) {
+	schema := newFakeSchema(baselineSchema...)
+
+	err := migrate(schema, Migrations(), LatestSchemaVersion())
+	if err != nil {
+		t.Fatalf("cannot migrate baseline schema: %v", err)
+	}
+	if len(schema.applied) != LatestSchemaVersion() {
+		t.Fatalf("expect %v applied migrations, got %v", LatestSchemaVersion(), len(schema.applied))
+	}
+	for _, migration := range Migrations() {
+		if migration.Column != "" && !schema.hasColumn(migration.Table, migration.Column) {
+			t.Fatalf("column %v.%v is not added to the baseline schema", migration.Table, migration.Column)
+		}
+	}
+
+	err = migrate(schema, Migrations(), 0)
+	if err != nil {
+		t.Fatalf("cannot migrate down: %v", err)
+	}
+	if len(schema.applied) != 0 || len(schema.tables) != 0 {
+		t.Fatalf("unexpected schema %v after migrating down", schema.tables)
+	}
+}
+
+func TestMigrateExistingColumn(t *testing.T) {
+	migrations := []Migration{
+		{
+			Version: 1,
+			Name:    "create table",
+			Up:      []string{"create table if not exists `device` (`id` varchar(36) not null,`note` text)"},
+			Down:    []string{"drop table if exists `device`"},
+		},
+		addColumnMigration(2, "add note", "device", "note", "text"),
+		addColumnMigration(3, "add tag", "device", "tag", "varchar(64) not null default ''"),
+	}
+
+	schema := newFakeSchema()
+	err := migrate(schema, migrations, 3)
+	if err != nil {
+		t.Fatalf("cannot migrate: %v", err)
+	}
+	if !schema.hasColumn("device", "note") || !schema.hasColumn("device", "tag") {
+		t.Fatalf("unexpected schema %v", schema.tables)
+	}
+
+	err = schema.execMigration("alter table `device` drop column `note`")
+	if err != nil {
+		t.Fatalf("cannot drop column: %v", err)
+	}
+	err = migrate(schema, migrations, 1)
+	if err != nil {
+		t.Fatalf("cannot migrate down: %v", err)
+	}
+	if schema.hasColumn("device", "tag") {
+		t.Fatalf("column tag is not dropped")
+	}
+}
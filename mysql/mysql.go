@@ -67,32 +67,33 @@ func (cli *MysqlCli) Delete() {
 }
 
 type DeviceConfig struct {
-	Maintaining   bool      `gorm:"column:maintaining"`
-	Offline       bool      `gorm:"column:offline"`
-	CreateTime    time.Time `gorm:"column:create_time"`
-	ModifyTime    time.Time `gorm:"column:modify_time"`
-	NvmeDesc      string    `gorm:"column:nvme_desc"`
-	GpuDesc       string    `gorm:"column:gpu_desc"`
-	MemoryDesc    string    `gorm:"column:memory_desc"`
-	CpuDesc       string    `gorm:"column:cpu_desc"`
-	HddDesc       string    `gorm:"column:hdd_desc"`
-	EthernetDesc  string    `gorm:"column:ethernet_desc"`
-	Id            uuid.UUID `gorm:"column:id"`
-	Spec          string    `gorm:"column:spec"`
-	ParentSpec    string    `gorm:"column:parent_spec"`
-	Role          string    `gorm:"column:role"`
-	SubRole       string    `gorm:"column:sub_role"`
-	Owner         string    `gorm:"column:owner"`
-	CurrentUser   string    `gorm:"column:current_user"`
-	Manager       string    `gorm:"column:manager"`
-	NvmeCount     int       `gorm:"column:nvme_count"`
-	GpuCount      int       `gorm:"column:gpu_count"`
-	MemoryCount   int       `gorm:"column:memory_count"`
-	MemorySize    uint64    `gorm:"column:memory_size"`
-	CpuCount      int       `gorm:"column:cpu_count"`
-	HddCount      int       `gorm:"column:hdd_count"`
-	EthernetCount int       `gorm:"column:ethernet_count"`
-	OsSpec        string    `gorm:"column:os_spec"`
+	Maintaining    bool      `gorm:"column:maintaining"`
+	Offline        bool      `gorm:"column:offline"`
+	Decommissioned bool      `gorm:"column:decommissioned"`
+	CreateTime     time.Time `gorm:"column:create_time"`
+	ModifyTime     time.Time `gorm:"column:modify_time"`
+	NvmeDesc       string    `gorm:"column:nvme_desc"`
+	GpuDesc        string    `gorm:"column:gpu_desc"`
+	MemoryDesc     string    `gorm:"column:memory_desc"`
+	CpuDesc        string    `gorm:"column:cpu_desc"`
+	HddDesc        string    `gorm:"column:hdd_desc"`
+	EthernetDesc   string    `gorm:"column:ethernet_desc"`
+	Id             uuid.UUID `gorm:"column:id"`
+	Spec           string    `gorm:"column:spec"`
+	ParentSpec     string    `gorm:"column:parent_spec"`
+	Role           string    `gorm:"column:role"`
+	SubRole        string    `gorm:"column:sub_role"`
+	Owner          string    `gorm:"column:owner"`
+	CurrentUser    string    `gorm:"column:current_user"`
+	Manager        string    `gorm:"column:manager"`
+	NvmeCount      int       `gorm:"column:nvme_count"`
+	GpuCount       int       `gorm:"column:gpu_count"`
+	MemoryCount    int       `gorm:"column:memory_count"`
+	MemorySize     uint64    `gorm:"column:memory_size"`
+	CpuCount       int       `gorm:"column:cpu_count"`
+	HddCount       int       `gorm:"column:hdd_count"`
+	EthernetCount  int       `gorm:"column:ethernet_count"`
+	OsSpec         string    `gorm:"column:os_spec"`
 }
 
 func (cli *MysqlCli) QueryDeviceConfig(id uuid.UUID) (*DeviceConfig, error) {
@@ -133,9 +134,14 @@ func (cli *MysqlCli) InsertDeviceConfig(info DeviceConfig) error {
 	if oldInfo == nil {
 		updateInfo = &info
 	} else {
-		if oldInfo.Maintaining {
+		if oldInfo.Maintaining || oldInfo.Decommissioned {
 			info.ParentSpec = oldInfo.ParentSpec
+			info.CreateTime = oldInfo.CreateTime
+			info.Maintaining = oldInfo.Maintaining
+			info.Offline = oldInfo.Offline
+			info.Decommissioned = oldInfo.Decommissioned
 			updateInfo = &info
+			couldBeUpdated = true
 		}
 	}
 
@@ -175,24 +181,6 @@ func (cli *MysqlCli) QueryDeviceConfigsByUser(username string) ([]DeviceConfig,
 	return infos, nil
 }
 
-type DeviceRole struct {
-	Id       string `gorm:"column:id"`
-	RoleName string `gorm:"column:role_name"`
-}
-
-func (cli *MysqlCli) ValidateRole(role string) (bool, error) {
-	var info DeviceRole
-	count := 0
-	rc := cli.db.Where("role_name = ?", role).Find(&info).Count(&count)
-	if rc.Error != nil {
-		return false, rc.Error
-	}
-	if count == 0 {
-		return false, nil
-	}
-	return true, nil
-}
-
 func (cli *MysqlCli) SetDeviceMaintaining(id uuid.UUID, maintaining bool) error {
 	var info DeviceConfig
 	var count int
@@ -206,3 +194,37 @@ func (cli *MysqlCli) SetDeviceMaintaining(id uuid.UUID, maintaining bool) error
 
 	return cli.db.Save(info).Error
 }
+
+func (cli *MysqlCli) SetDevicesMaintaining(ids []uuid.UUID, maintaining bool) (map[uuid.UUID]error, error) {
+	failures := map[uuid.UUID]error{}
+
+	tx := cli.db.Begin()
+
+	for _, id := range ids {
+		count := 0
+		rc := tx.Model(&DeviceConfig{}).Where("id = ?", id).Count(&count)
+		if rc.Error != nil {
+			failures[id] = rc.Error
+			continue
+		}
+		if count == 0 {
+			failures[id] = xerrors.Errorf("cannot find any value")
+			continue
+		}
+
+		rc = tx.Model(&DeviceConfig{}).Where("id = ?", id).Updates(map[string]interface{}{
+			"maintaining": maintaining,
+			"modify_time": time.Now(),
+		})
+		if rc.Error != nil {
+			failures[id] = rc.Error
+		}
+	}
+
+	if len(failures) > 0 {
+		tx.Rollback()
+		return failures, xerrors.Errorf("%v of %v devices failed, rolled back", len(failures), len(ids))
+	}
+
+	return failures, tx.Commit().Error
+}
@@ -0,0 +1,67 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type DeviceOwnership struct {
+	Id          uuid.UUID `gorm:"column:id;primary_key"`
+	DeviceId    uuid.UUID `gorm:"column:device_id"`
+	Owner       string    `gorm:"column:owner"`
+	CurrentUser string    `gorm:"column:current_user"`
+	Manager     string    `gorm:"column:manager"`
+	PrevOwner   string    `gorm:"column:prev_owner"`
+	PrevUser    string    `gorm:"column:prev_user"`
+	PrevManager string    `gorm:"column:prev_manager"`
+	Operator    string    `gorm:"column:operator"`
+	CreateTime  time.Time `gorm:"column:create_time"`
+}
+
+func (cli *MysqlCli) TransferDevice(id uuid.UUID, owner, currentUser, manager, operator string) error {
+	info, err := cli.QueryDeviceConfig(id)
+	if err != nil {
+		return err
+	}
+
+	tx := cli.db.Begin()
+
+	rc := tx.Model(&DeviceConfig{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"owner":        owner,
+		"current_user": currentUser,
+		"manager":      manager,
+		"modify_time":  time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	rc = tx.Create(&DeviceOwnership{
+		Id:          uuid.New(),
+		DeviceId:    id,
+		Owner:       owner,
+		CurrentUser: currentUser,
+		Manager:     manager,
+		PrevOwner:   info.Owner,
+		PrevUser:    info.CurrentUser,
+		PrevManager: info.Manager,
+		Operator:    operator,
+		CreateTime:  time.Now(),
+	})
+	if rc.Error != nil {
+		tx.Rollback()
+		return rc.Error
+	}
+
+	return tx.Commit().Error
+}
+
+func (cli *MysqlCli) QueryDeviceOwnerships(deviceId uuid.UUID) ([]DeviceOwnership, error) {
+	var infos []DeviceOwnership
+	rc := cli.db.Where("device_id = ?", deviceId).Order("create_time desc").Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
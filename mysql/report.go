@@ -0,0 +1,105 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type DeviceReport struct {
+	Id          uuid.UUID `gorm:"column:id;primary_key"`
+	DeviceId    uuid.UUID `gorm:"column:device_id"`
+	NvmeCount   int       `gorm:"column:nvme_count"`
+	GpuCount    int       `gorm:"column:gpu_count"`
+	MemoryCount int       `gorm:"column:memory_count"`
+	MemorySize  uint64    `gorm:"column:memory_size"`
+	HddCount    int       `gorm:"column:hdd_count"`
+	LocalAddr   string    `gorm:"column:local_addr"`
+	PublicAddr  string    `gorm:"column:public_addr"`
+	CreateTime  time.Time `gorm:"column:create_time"`
+}
+
+func (cli *MysqlCli) InsertDeviceReport(info DeviceReport) error {
+	if info.Id == uuid.Nil {
+		info.Id = uuid.New()
+	}
+	info.CreateTime = time.Now()
+	return cli.db.Create(&info).Error
+}
+
+func (cli *MysqlCli) QueryDeviceReports(deviceId uuid.UUID, limit int) ([]DeviceReport, error) {
+	var infos []DeviceReport
+	rc := cli.db.Where("device_id = ?", deviceId).Order("create_time desc").Limit(limit).Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+func (cli *MysqlCli) PruneDeviceReports(before time.Time) (int64, error) {
+	rc := cli.db.Where("create_time < ?", before).Delete(&DeviceReport{})
+	return rc.RowsAffected, rc.Error
+}
+
+const (
+	DriftItemNvme        = "nvme"
+	DriftItemGpu         = "gpu"
+	DriftItemMemoryCount = "memory"
+	DriftItemHdd         = "hdd"
+)
+
+type DeviceDrift struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key"`
+	DeviceId   uuid.UUID `gorm:"column:device_id"`
+	Item       string    `gorm:"column:item"`
+	Expected   int       `gorm:"column:expected"`
+	Actual     int       `gorm:"column:actual"`
+	CreateTime time.Time `gorm:"column:create_time"`
+}
+
+func (cli *MysqlCli) QueryLatestDeviceDrift(deviceId uuid.UUID, item string) (*DeviceDrift, error) {
+	var infos []DeviceDrift
+	rc := cli.db.Where("device_id = ? and item = ?", deviceId, item).Order("create_time desc").Limit(1).Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	if len(infos) == 0 {
+		return nil, nil
+	}
+	return &infos[0], nil
+}
+
+// InsertDeviceDrift only records a new event when the actual value moved since
+// the last event of the same item, so a device that keeps reporting the same
+// broken state does not flood the table. An event whose actual value equals the
+// expected one marks the item as recovered.
+func (cli *MysqlCli) InsertDeviceDrift(info DeviceDrift) (bool, error) {
+	latest, err := cli.QueryLatestDeviceDrift(info.DeviceId, info.Item)
+	if err != nil {
+		return false, err
+	}
+
+	if info.Actual == info.Expected && latest == nil {
+		return false, nil
+	}
+	if latest != nil && latest.Actual == info.Actual && latest.Expected == info.Expected {
+		return false, nil
+	}
+
+	info.Id = uuid.New()
+	info.CreateTime = time.Now()
+
+	return true, cli.db.Create(&info).Error
+}
+
+func (cli *MysqlCli) QueryDeviceDrifts(deviceIds []uuid.UUID) ([]DeviceDrift, error) {
+	var infos []DeviceDrift
+	db := cli.db
+	if deviceIds != nil {
+		db = db.Where("device_id in (?)", deviceIds)
+	}
+	rc := db.Order("create_time desc").Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
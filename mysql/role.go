@@ -0,0 +1,117 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"strings"
+)
+
+type DeviceRole struct {
+	Id       string `gorm:"column:id"`
+	RoleName string `gorm:"column:role_name"`
+	SubRoles string `gorm:"column:sub_roles"`
+}
+
+func (cli *MysqlCli) ValidateRole(role string) (bool, error) {
+	var info DeviceRole
+	count := 0
+	rc := cli.db.Where("role_name = ?", role).Find(&info).Count(&count)
+	if rc.Error != nil {
+		return false, rc.Error
+	}
+	if count == 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+func (cli *MysqlCli) ValidateSubRole(role string, subRole string) (bool, error) {
+	info, err := cli.QueryDeviceRole(role)
+	if err != nil {
+		return false, err
+	}
+	if info.SubRoles == "" {
+		return true, nil
+	}
+	for _, allowed := range strings.Split(info.SubRoles, ",") {
+		if allowed == subRole {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (cli *MysqlCli) QueryDeviceRole(role string) (*DeviceRole, error) {
+	var info DeviceRole
+	var count int
+
+	cli.db.Where("role_name = ?", role).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) QueryDeviceRoles() ([]DeviceRole, error) {
+	var infos []DeviceRole
+	rc := cli.db.Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+func (cli *MysqlCli) InsertDeviceRole(role string, subRoles []string) error {
+	_, err := cli.QueryDeviceRole(role)
+	if err == nil {
+		return xerrors.Errorf("role %v already exists", role)
+	}
+
+	return cli.db.Create(&DeviceRole{
+		Id:       uuid.New().String(),
+		RoleName: role,
+		SubRoles: strings.Join(subRoles, ","),
+	}).Error
+}
+
+func (cli *MysqlCli) UpdateDeviceRole(role string, subRoles []string) error {
+	info, err := cli.QueryDeviceRole(role)
+	if err != nil {
+		return err
+	}
+
+	if len(subRoles) > 0 {
+		count := 0
+		rc := cli.db.Model(&DeviceConfig{}).
+			Where("role = ? and decommissioned = ? and sub_role not in (?)", role, false, subRoles).
+			Count(&count)
+		if rc.Error != nil {
+			return rc.Error
+		}
+		if count > 0 {
+			return xerrors.Errorf("%v devices still use sub roles out of %v", count, subRoles)
+		}
+	}
+
+	return cli.db.Model(&DeviceRole{}).Where("role_name = ?", info.RoleName).
+		Update("sub_roles", strings.Join(subRoles, ",")).Error
+}
+
+func (cli *MysqlCli) DeleteDeviceRole(role string) error {
+	_, err := cli.QueryDeviceRole(role)
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	rc := cli.db.Model(&DeviceConfig{}).Where("role = ? and decommissioned = ?", role, false).Count(&count)
+	if rc.Error != nil {
+		return rc.Error
+	}
+	if count > 0 {
+		return xerrors.Errorf("role %v is still used by %v devices", role, count)
+	}
+
+	return cli.db.Where("role_name = ?", role).Delete(&DeviceRole{}).Error
+}
@@ -0,0 +1,33 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+type DeviceSecret struct {
+	DeviceId   uuid.UUID `gorm:"column:device_id;primary_key"`
+	Secret     string    `gorm:"column:secret"`
+	CreateTime time.Time `gorm:"column:create_time"`
+}
+
+func (cli *MysqlCli) QueryDeviceSecret(deviceId uuid.UUID) (*DeviceSecret, error) {
+	var info DeviceSecret
+	var count int
+
+	cli.db.Where("device_id = ?", deviceId).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) UpdateDeviceSecret(deviceId uuid.UUID, secret string) error {
+	return cli.db.Save(&DeviceSecret{
+		DeviceId:   deviceId,
+		Secret:     secret,
+		CreateTime: time.Now(),
+	}).Error
+}
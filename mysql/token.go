@@ -0,0 +1,72 @@
+package devopsmysql
+
+import (
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+	"time"
+)
+
+type ApiToken struct {
+	Id         uuid.UUID `gorm:"column:id;primary_key"`
+	Name       string    `gorm:"column:name"`
+	TokenHash  string    `gorm:"column:token_hash"`
+	Username   string    `gorm:"column:username"`
+	SuperUser  bool      `gorm:"column:super_user"`
+	Scopes     string    `gorm:"column:scopes"`
+	Revoked    bool      `gorm:"column:revoked"`
+	ExpireTime time.Time `gorm:"column:expire_time"`
+	CreateTime time.Time `gorm:"column:create_time"`
+}
+
+func (cli *MysqlCli) InsertApiToken(info ApiToken) error {
+	info.CreateTime = time.Now()
+	return cli.db.Create(&info).Error
+}
+
+func (cli *MysqlCli) QueryApiTokenByHash(hash string) (*ApiToken, error) {
+	var info ApiToken
+	var count int
+
+	cli.db.Where("token_hash = ?", hash).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) QueryApiToken(id uuid.UUID) (*ApiToken, error) {
+	var info ApiToken
+	var count int
+
+	cli.db.Where("id = ?", id).Find(&info).Count(&count)
+	if count == 0 {
+		return nil, xerrors.Errorf("cannot find any value")
+	}
+
+	return &info, nil
+}
+
+func (cli *MysqlCli) QueryApiTokens(username string) ([]ApiToken, error) {
+	var infos []ApiToken
+	db := cli.db
+	if username != "" {
+		db = db.Where("username = ?", username)
+	}
+	rc := db.Order("create_time desc").Find(&infos)
+	if rc.Error != nil {
+		return nil, rc.Error
+	}
+	return infos, nil
+}
+
+func (cli *MysqlCli) RevokeApiToken(id uuid.UUID) error {
+	return cli.db.Model(&ApiToken{}).Where("id = ?", id).Update("revoked", true).Error
+}
+
+func (cli *MysqlCli) RevokeSuperApiTokens(username string) (int, error) {
+	rc := cli.db.Model(&ApiToken{}).
+		Where("username = ? and super_user = ? and revoked = ?", username, true, false).
+		Update("revoked", true)
+	return int(rc.RowsAffected), rc.Error
+}
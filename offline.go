@@ -0,0 +1,109 @@
+package main
+
+import (
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultOfflineInterval     = 300
+	defaultReportRetentionDays = 30
+)
+
+func (s *DevopsServer) offlineInterval() time.Duration {
+	interval := s.config.OfflineInterval
+	if interval <= 0 {
+		interval = defaultOfflineInterval
+	}
+	return time.Duration(interval) * time.Second
+}
+
+func (s *DevopsServer) heartbeat(id uuid.UUID, offline bool) {
+	err := s.deviceStore.UpdateDeviceHeartbeat(id, time.Now())
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to update heartbeat of %v: %v", id, err)
+		return
+	}
+
+	if !offline {
+		return
+	}
+
+	err = s.deviceStore.SetDeviceOffline(id, false)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to set %v online: %v", id, err)
+		return
+	}
+	log.Infof(log.Fields{}, "device %v is back online", id)
+}
+
+func (s *DevopsServer) checkOfflineDevices() {
+	configs, err := s.deviceStore.QueryDeviceConfigs()
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query devices: %v", err)
+		return
+	}
+
+	heartbeats, err := s.deviceStore.QueryDeviceHeartbeats()
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to query heartbeats: %v", err)
+		return
+	}
+
+	now := time.Now()
+	interval := s.offlineInterval()
+
+	for _, config := range configs {
+		if config.Maintaining || config.Offline || config.Decommissioned {
+			continue
+		}
+
+		last, ok := heartbeats[config.Id]
+		if !ok {
+			last = config.CreateTime
+		}
+
+		if now.Sub(last) < interval {
+			continue
+		}
+
+		err = s.deviceStore.SetDeviceOffline(config.Id, true)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to set %v offline: %v", config.Id, err)
+			continue
+		}
+		log.Infof(log.Fields{}, "device %v is offline, last report at %v", config.Id, last)
+	}
+}
+
+func (s *DevopsServer) reportRetention() time.Duration {
+	days := s.config.ReportRetentionDays
+	if days <= 0 {
+		days = defaultReportRetentionDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// pruneDeviceReports drops report history older than the retention, drift
+// events are kept since they are already deduplicated.
+func (s *DevopsServer) pruneDeviceReports() {
+	before := time.Now().Add(-s.reportRetention())
+	pruned, err := s.deviceStore.PruneDeviceReports(before)
+	if err != nil {
+		log.Errorf(log.Fields{}, "fail to prune reports before %v: %v", before, err)
+		return
+	}
+	if pruned > 0 {
+		log.Infof(log.Fields{}, "pruned %v reports before %v", pruned, before)
+	}
+}
+
+func (s *DevopsServer) offlineWatcher() {
+	ticker := time.NewTicker(s.offlineInterval() / 2)
+	for range ticker.C {
+		s.checkOfflineDevices()
+		s.pruneDeviceReports()
+	}
+}
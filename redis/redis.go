@@ -77,6 +77,10 @@ func (cli *RedisCli) InsertKeyInfo(keyWord string, id uuid.UUID, info interface{
 	return nil
 }
 
+func (cli *RedisCli) DeleteKeyInfo(keyWord string, id uuid.UUID) error {
+	return cli.client.Del(fmt.Sprintf("%v:%v:%v", redisKeyPrefix, keyWord, id)).Err()
+}
+
 func (cli *RedisCli) QueryDevice(cid uuid.UUID) (*types.DeviceConfig, error) {
 	val, err := cli.client.Get(fmt.Sprintf("%v:device:%v", redisKeyPrefix, cid)).Result()
 	if err != nil {
@@ -89,3 +93,76 @@ func (cli *RedisCli) QueryDevice(cid uuid.UUID) (*types.DeviceConfig, error) {
 	}
 	return info, nil
 }
+
+const queryDevicesChunkSize = 500
+
+func (cli *RedisCli) QueryDevices(cids []uuid.UUID) (map[uuid.UUID]*types.DeviceConfig, error) {
+	infos := map[uuid.UUID]*types.DeviceConfig{}
+	if len(cids) == 0 {
+		return infos, nil
+	}
+
+	pipe := cli.client.Pipeline()
+	defer pipe.Close()
+
+	cmds := []*redis.SliceCmd{}
+	for start := 0; start < len(cids); start += queryDevicesChunkSize {
+		end := start + queryDevicesChunkSize
+		if end > len(cids) {
+			end = len(cids)
+		}
+		keys := []string{}
+		for _, cid := range cids[start:end] {
+			keys = append(keys, fmt.Sprintf("%v:device:%v", redisKeyPrefix, cid))
+		}
+		cmds = append(cmds, pipe.MGet(keys...))
+	}
+
+	_, err := pipe.Exec()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, cmd := range cmds {
+		for j, val := range cmd.Val() {
+			str, ok := val.(string)
+			if !ok {
+				continue
+			}
+			info := &types.DeviceConfig{}
+			err = json.Unmarshal([]byte(str), info)
+			if err != nil {
+				log.Errorf(log.Fields{}, "invalid device cache %v: %v", cids[i*queryDevicesChunkSize+j], err)
+				continue
+			}
+			infos[cids[i*queryDevicesChunkSize+j]] = info
+		}
+	}
+
+	return infos, nil
+}
+
+func (cli *RedisCli) InsertAlertMgrAddresses(infos []types.AlertMgrAddress, ttl time.Duration) error {
+	b, err := json.Marshal(infos)
+	if err != nil {
+		return err
+	}
+	return cli.client.Set(fmt.Sprintf("%v:alertmgr:addresses", redisKeyPrefix), string(b), ttl).Err()
+}
+
+func (cli *RedisCli) QueryAlertMgrAddresses() ([]types.AlertMgrAddress, error) {
+	val, err := cli.client.Get(fmt.Sprintf("%v:alertmgr:addresses", redisKeyPrefix)).Result()
+	if err != nil {
+		return nil, err
+	}
+	infos := []types.AlertMgrAddress{}
+	err = json.Unmarshal([]byte(val), &infos)
+	if err != nil {
+		return nil, err
+	}
+	return infos, nil
+}
+
+func (cli *RedisCli) InsertNonce(id uuid.UUID, nonce string, ttl time.Duration) (bool, error) {
+	return cli.client.SetNX(fmt.Sprintf("%v:nonce:%v:%v", redisKeyPrefix, id, nonce), "1", ttl).Result()
+}
@@ -0,0 +1,252 @@
+package fbcredis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/go-redis/redis"
+	"github.com/google/uuid"
+)
+
+// memRedis speaks just enough RESP for RedisCli: GET, SET, MGET, DEL and PING.
+type memRedis struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	values   map[string]string
+}
+
+func newMemRedis(t testing.TB) *memRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot listen: %v", err)
+	}
+
+	mem := &memRedis{
+		listener: listener,
+		values:   map[string]string{},
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go mem.serve(conn)
+		}
+	}()
+
+	return mem
+}
+
+func (mem *memRedis) Close() {
+	mem.listener.Close()
+}
+
+func (mem *memRedis) cli() *RedisCli {
+	return &RedisCli{
+		client: redis.NewClient(&redis.Options{
+			Addr: mem.listener.Addr().String(),
+		}),
+	}
+}
+
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return nil, fmt.Errorf("unexpected line %v", line)
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	args := []string{}
+	for i := 0; i < count; i++ {
+		line, err = readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		_, err = io.ReadFull(r, buf)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+
+	return args, nil
+}
+
+func writeBulk(w *bufio.Writer, val string, ok bool) {
+	if !ok {
+		w.WriteString("$-1\r\n")
+		return
+	}
+	fmt.Fprintf(w, "$%v\r\n%v\r\n", len(val), val)
+}
+
+func (mem *memRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+
+		mem.mutex.Lock()
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			w.WriteString("+PONG\r\n")
+		case "SET":
+			mem.values[args[1]] = args[2]
+			w.WriteString("+OK\r\n")
+		case "GET":
+			val, ok := mem.values[args[1]]
+			writeBulk(w, val, ok)
+		case "MGET":
+			fmt.Fprintf(w, "*%v\r\n", len(args)-1)
+			for _, key := range args[1:] {
+				val, ok := mem.values[key]
+				writeBulk(w, val, ok)
+			}
+		case "DEL":
+			count := 0
+			for _, key := range args[1:] {
+				if _, ok := mem.values[key]; ok {
+					delete(mem.values, key)
+					count++
+				}
+			}
+			fmt.Fprintf(w, ":%v\r\n", count)
+		default:
+			fmt.Fprintf(w, "-ERR unknown command '%v'\r\n", args[0])
+		}
+		mem.mutex.Unlock()
+
+		if r.Buffered() == 0 {
+			w.Flush()
+		}
+	}
+}
+
+func insertDevices(t testing.TB, cli *RedisCli, count int) []uuid.UUID {
+	ids := []uuid.UUID{}
+	for i := 0; i < count; i++ {
+		id := uuid.New()
+		err := cli.InsertKeyInfo("device", id, types.DeviceConfig{
+			Id:        id,
+			Spec:      fmt.Sprintf("spec-%v", i),
+			NvmeCount: i,
+			LocalAddr: fmt.Sprintf("10.0.%v.%v", i/256, i%256),
+		}, time.Hour)
+		if err != nil {
+			t.Fatalf("cannot insert device: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func TestQueryDevices(t *testing.T) {
+	mem := newMemRedis(t)
+	defer mem.Close()
+
+	cli := mem.cli()
+	ids := insertDevices(t, cli, 1200)
+	missing := uuid.New()
+
+	devices, err := cli.QueryDevices(append(ids, missing))
+	if err != nil {
+		t.Fatalf("cannot query devices: %v", err)
+	}
+
+	if len(devices) != len(ids) {
+		t.Fatalf("expect %v devices, got %v", len(ids), len(devices))
+	}
+	if _, ok := devices[missing]; ok {
+		t.Fatalf("unexpected device %v", missing)
+	}
+	for i, id := range ids {
+		device := devices[id]
+		if device == nil || device.Id != id || device.NvmeCount != i {
+			t.Fatalf("device %v mismatch: %v", id, device)
+		}
+	}
+
+	err = cli.DeleteKeyInfo("device", ids[0])
+	if err != nil {
+		t.Fatalf("cannot delete device: %v", err)
+	}
+	_, err = cli.QueryDevice(ids[0])
+	if err != redis.Nil {
+		t.Fatalf("expect %v, got %v", redis.Nil, err)
+	}
+}
+
+const benchmarkDevices = 5000
+
+func BenchmarkQueryDeviceOneByOne(b *testing.B) {
+	mem := newMemRedis(b)
+	defer mem.Close()
+
+	cli := mem.cli()
+	ids := insertDevices(b, cli, benchmarkDevices)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, id := range ids {
+			_, err := cli.QueryDevice(id)
+			if err != nil {
+				b.Fatalf("cannot query device: %v", err)
+			}
+		}
+	}
+}
+
+func BenchmarkQueryDevices(b *testing.B) {
+	mem := newMemRedis(b)
+	defer mem.Close()
+
+	cli := mem.cli()
+	ids := insertDevices(b, cli, benchmarkDevices)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		devices, err := cli.QueryDevices(ids)
+		if err != nil {
+			b.Fatalf("cannot query devices: %v", err)
+		}
+		if len(devices) != len(ids) {
+			b.Fatalf("expect %v devices, got %v", len(ids), len(devices))
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+func deviceRole(info devopsmysql.DeviceRole) types.DeviceRole {
+	role := types.DeviceRole{
+		Role:     info.RoleName,
+		SubRoles: []string{},
+	}
+	if info.SubRoles != "" {
+		role.SubRoles = strings.Split(info.SubRoles, ",")
+	}
+	return role
+}
+
+func (s *DevopsServer) roleSnapshot(role string) interface{} {
+	info, err := s.deviceStore.QueryDeviceRole(role)
+	if err != nil {
+		return nil
+	}
+	return deviceRole(*info)
+}
+
+func (s *DevopsServer) DeviceRolesRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceRolesInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	_, err = s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	infos, err := s.deviceStore.QueryDeviceRoles()
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	output := types.DeviceRolesOutput{
+		Roles: []types.DeviceRole{},
+	}
+	for _, info := range infos {
+		output.Roles = append(output.Roles, deviceRole(info))
+	}
+
+	return output, "", 0
+}
+
+func (s *DevopsServer) deviceRoleInput(req *http.Request) (*types.DeviceRoleInput, *authtypes.UserInfoOutput, types.ErrorCode, string) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return nil, nil, types.ErrInvalidRequest, err.Error()
+	}
+
+	input := types.DeviceRoleInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return nil, nil, types.ErrInvalidRequest, err.Error()
+	}
+
+	if input.Role == "" {
+		return nil, nil, types.ErrInvalidParam, "role is must"
+	}
+
+	for _, subRole := range input.SubRoles {
+		if subRole == "" || strings.Contains(subRole, ",") {
+			return nil, nil, types.ErrInvalidParam, "sub role is not valid"
+		}
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return nil, nil, types.ErrUnauthenticated, err.Error()
+	}
+
+	if !user.SuperUser {
+		return nil, nil, types.ErrPermissionDenied, "permission denied"
+	}
+
+	return &input, user, types.ErrorCode{}, ""
+}
+
+func (s *DevopsServer) DeviceRoleCreateRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, user, code, msg := s.deviceRoleInput(req)
+	if input == nil {
+		return fail(w, code, msg)
+	}
+
+	err := s.deviceStore.InsertDeviceRole(input.Role, input.SubRoles)
+	if err != nil {
+		return fail(w, types.ErrConflict, err.Error())
+	}
+
+	s.audit(req, user.Username, types.AuditActionRoleCreate, uuid.Nil, nil, s.roleSnapshot(input.Role))
+
+	return input.DeviceRole, "", 0
+}
+
+func (s *DevopsServer) DeviceRoleUpdateRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, user, code, msg := s.deviceRoleInput(req)
+	if input == nil {
+		return fail(w, code, msg)
+	}
+
+	before := s.roleSnapshot(input.Role)
+
+	err := s.deviceStore.UpdateDeviceRole(input.Role, input.SubRoles)
+	if err != nil {
+		return fail(w, types.ErrConflict, err.Error())
+	}
+
+	s.audit(req, user.Username, types.AuditActionRoleUpdate, uuid.Nil, before, s.roleSnapshot(input.Role))
+
+	return input.DeviceRole, "", 0
+}
+
+func (s *DevopsServer) DeviceRoleDeleteRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	input, user, code, msg := s.deviceRoleInput(req)
+	if input == nil {
+		return fail(w, code, msg)
+	}
+
+	before := s.roleSnapshot(input.Role)
+
+	err := s.deviceStore.DeleteDeviceRole(input.Role)
+	if err != nil {
+		return fail(w, types.ErrConflict, err.Error())
+	}
+
+	s.audit(req, user.Username, types.AuditActionRoleDelete, uuid.Nil, before, nil)
+
+	return nil, "", 0
+}
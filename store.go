@@ -0,0 +1,84 @@
+package main
+
+import (
+	"time"
+
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	devopsredis "github.com/NpoolDevOps/fbc-devops-service/redis"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+// DeviceStore is the persistent side of the server, devopsmysql.MysqlCli in
+// production and memstore.DeviceStore in tests.
+type DeviceStore interface {
+	QueryDeviceConfig(id uuid.UUID) (*devopsmysql.DeviceConfig, error)
+	InsertDeviceConfig(info devopsmysql.DeviceConfig) error
+	QueryDeviceConfigs() ([]devopsmysql.DeviceConfig, error)
+	QueryDeviceConfigsByFilter(filter devopsmysql.DeviceFilter) ([]devopsmysql.DeviceConfig, int, error)
+	SetDeviceMaintaining(id uuid.UUID, maintaining bool) error
+	SetDevicesMaintaining(ids []uuid.UUID, maintaining bool) (map[uuid.UUID]error, error)
+	DecommissionDevice(id uuid.UUID, reason string, operator string) error
+	TransferDevice(id uuid.UUID, owner, currentUser, manager, operator string) error
+	QueryDeviceOwnerships(deviceId uuid.UUID) ([]devopsmysql.DeviceOwnership, error)
+
+	InsertDeviceReport(info devopsmysql.DeviceReport) error
+	QueryDeviceReports(deviceId uuid.UUID, limit int) ([]devopsmysql.DeviceReport, error)
+	PruneDeviceReports(before time.Time) (int64, error)
+	InsertDeviceDrift(info devopsmysql.DeviceDrift) (bool, error)
+	QueryDeviceDrifts(deviceIds []uuid.UUID) ([]devopsmysql.DeviceDrift, error)
+	UpdateDeviceHeartbeat(deviceId uuid.UUID, reportTime time.Time) error
+	QueryDeviceHeartbeats() (map[uuid.UUID]time.Time, error)
+	SetDeviceOffline(id uuid.UUID, offline bool) error
+	QueryDeviceStatusChanges(deviceId uuid.UUID, limit int) ([]devopsmysql.DeviceStatusChange, error)
+
+	ValidateRole(role string) (bool, error)
+	ValidateSubRole(role string, subRole string) (bool, error)
+	QueryDeviceRole(role string) (*devopsmysql.DeviceRole, error)
+	QueryDeviceRoles() ([]devopsmysql.DeviceRole, error)
+	InsertDeviceRole(role string, subRoles []string) error
+	UpdateDeviceRole(role string, subRoles []string) error
+	DeleteDeviceRole(role string) error
+
+	QueryAlertMgrAddress(id uuid.UUID) (*devopsmysql.AlertMgrAddress, error)
+	QueryAlertMgrAddresses() ([]devopsmysql.AlertMgrAddress, error)
+	InsertAlertMgrAddress(info devopsmysql.AlertMgrAddress) error
+
+	QueryDeviceSecret(deviceId uuid.UUID) (*devopsmysql.DeviceSecret, error)
+	UpdateDeviceSecret(deviceId uuid.UUID, secret string) error
+
+	InsertApiToken(info devopsmysql.ApiToken) error
+	QueryApiTokenByHash(hash string) (*devopsmysql.ApiToken, error)
+	QueryApiToken(id uuid.UUID) (*devopsmysql.ApiToken, error)
+	QueryApiTokens(username string) ([]devopsmysql.ApiToken, error)
+	RevokeApiToken(id uuid.UUID) error
+	RevokeSuperApiTokens(username string) (int, error)
+
+	InsertAuditLog(info devopsmysql.AuditLog) error
+	QueryAuditLogs(filter devopsmysql.AuditFilter) ([]devopsmysql.AuditLog, error)
+
+	InsertMaintenanceWindow(info devopsmysql.MaintenanceWindow) error
+	QueryMaintenanceWindow(id uuid.UUID) (*devopsmysql.MaintenanceWindow, error)
+	QueryMaintenanceWindows(deviceIds []uuid.UUID) ([]devopsmysql.MaintenanceWindow, error)
+	QueryDueMaintenanceWindows(now time.Time) ([]devopsmysql.MaintenanceWindow, error)
+	QueryExpiredMaintenanceWindows(now time.Time) ([]devopsmysql.MaintenanceWindow, error)
+	CountActiveMaintenanceWindows(deviceId uuid.UUID, except uuid.UUID) (int, error)
+	UpdateMaintenanceWindow(info devopsmysql.MaintenanceWindow) error
+}
+
+// RuntimeCache holds what devices report at runtime, devopsredis.RedisCli in
+// production and memstore.RuntimeCache in tests.
+type RuntimeCache interface {
+	InsertKeyInfo(keyWord string, id uuid.UUID, info interface{}, ttl time.Duration) error
+	DeleteKeyInfo(keyWord string, id uuid.UUID) error
+	QueryDevice(cid uuid.UUID) (*types.DeviceConfig, error)
+	QueryDevices(cids []uuid.UUID) (map[uuid.UUID]*types.DeviceConfig, error)
+	InsertAlertMgrAddresses(infos []types.AlertMgrAddress, ttl time.Duration) error
+	QueryAlertMgrAddresses() ([]types.AlertMgrAddress, error)
+	InsertNonce(id uuid.UUID, nonce string, ttl time.Duration) (bool, error)
+}
+
+var (
+	_ DeviceStore  = (*devopsmysql.MysqlCli)(nil)
+	_ RuntimeCache = (*devopsredis.RedisCli)(nil)
+)
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/EntropyPool/entropy-logger"
+	authtypes "github.com/NpoolDevOps/fbc-auth-service/types"
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+const (
+	apiTokenPrefix        = "fbcdt_"
+	defaultApiTokenExpire = 30 * 24 * time.Hour
+	// Super user tokens outlive a demotion of their owner when the provider
+	// cannot look owners up, so they are kept short.
+	superApiTokenExpire = 12 * time.Hour
+)
+
+func hashApiToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newApiToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%v%v", apiTokenPrefix, hex.EncodeToString(b)), nil
+}
+
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return xerrors.Errorf("scopes is must")
+	}
+	for _, scope := range scopes {
+		if scope != types.TokenScopeRead && scope != types.TokenScopeWrite {
+			return xerrors.Errorf("invalid scope %v", scope)
+		}
+	}
+	return nil
+}
+
+// authenticate accepts either an API token in the Authorization header or an
+// auth code checked by the configured authenticator, the token taking
+// precedence.
+func (s *DevopsServer) authenticate(req *http.Request, authCode string, scope string) (*authtypes.UserInfoOutput, error) {
+	token := bearerToken(req)
+	if token == "" {
+		if authCode == "" {
+			return nil, xerrors.Errorf("auth code is must")
+		}
+		return s.userInfo(authCode)
+	}
+
+	info, err := s.deviceStore.QueryApiTokenByHash(hashApiToken(token))
+	if err != nil {
+		return nil, xerrors.Errorf("invalid token")
+	}
+
+	if info.Revoked {
+		return nil, xerrors.Errorf("token is revoked")
+	}
+
+	if time.Now().After(info.ExpireTime) {
+		return nil, xerrors.Errorf("token is expired")
+	}
+
+	granted := false
+	for _, tokenScope := range strings.Split(info.Scopes, ",") {
+		if tokenScope == scope {
+			granted = true
+			break
+		}
+	}
+	if !granted {
+		return nil, xerrors.Errorf("token is not granted %v scope", scope)
+	}
+
+	superUser := info.SuperUser
+	if resolver, ok := s.authenticator.(UserResolver); ok && superUser {
+		owner, err := resolver.UserByName(info.Username)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid token owner %v: %v", info.Username, err)
+		}
+		superUser = owner.SuperUser
+	} else if superUser && info.ExpireTime.Sub(info.CreateTime) > superApiTokenExpire {
+		superUser = false
+	}
+
+	return &authtypes.UserInfoOutput{
+		Username:  info.Username,
+		SuperUser: superUser,
+	}, nil
+}
+
+// userInfo resolves an auth code and revokes the super user tokens of a user
+// who is no longer super user, for providers which cannot look owners up when
+// the tokens are used. Until that user signs in again, the short expiry of
+// super user tokens bounds how long the old privileges last.
+func (s *DevopsServer) userInfo(authCode string) (*authtypes.UserInfoOutput, error) {
+	user, err := s.authenticator.UserInfo(authCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if !user.SuperUser {
+		count, err := s.deviceStore.RevokeSuperApiTokens(user.Username)
+		if err != nil {
+			log.Errorf(log.Fields{}, "fail to revoke super user tokens of %v: %v", user.Username, err)
+		} else if count > 0 {
+			log.Infof(log.Fields{}, "revoked %v super user tokens of demoted %v", count, user.Username)
+		}
+	}
+
+	return user, nil
+}
+
+func apiToken(info devopsmysql.ApiToken) types.ApiToken {
+	return types.ApiToken{
+		Id:         info.Id,
+		Name:       info.Name,
+		Username:   info.Username,
+		SuperUser:  info.SuperUser,
+		Scopes:     strings.Split(info.Scopes, ","),
+		Revoked:    info.Revoked,
+		ExpireTime: info.ExpireTime,
+		CreateTime: info.CreateTime,
+	}
+}
+
+func (s *DevopsServer) ApiTokenCreateRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.ApiTokenCreateInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	if input.AuthCode == "" {
+		return fail(w, types.ErrAuthRequired, "auth code is must")
+	}
+
+	if input.Name == "" {
+		return fail(w, types.ErrInvalidParam, "name is must")
+	}
+
+	err = validateScopes(input.Scopes)
+	if err != nil {
+		return fail(w, types.ErrInvalidParam, err.Error())
+	}
+
+	user, err := s.userInfo(input.AuthCode)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	if input.SuperUser && !user.SuperUser {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	expire := defaultApiTokenExpire
+	if input.Expire > 0 {
+		expire = time.Duration(input.Expire) * time.Second
+	}
+	if _, ok := s.authenticator.(UserResolver); !ok && input.SuperUser && expire > superApiTokenExpire {
+		expire = superApiTokenExpire
+	}
+
+	token, err := newApiToken()
+	if err != nil {
+		return fail(w, types.ErrInternal, err.Error())
+	}
+
+	info := devopsmysql.ApiToken{
+		Id:         uuid.New(),
+		Name:       input.Name,
+		TokenHash:  hashApiToken(token),
+		Username:   user.Username,
+		SuperUser:  input.SuperUser,
+		Scopes:     strings.Join(input.Scopes, ","),
+		ExpireTime: time.Now().Add(expire),
+	}
+
+	err = s.deviceStore.InsertApiToken(info)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	s.audit(req, user.Username, types.AuditActionTokenCreate, uuid.Nil, nil, apiToken(info))
+
+	return types.ApiTokenCreateOutput{
+		Id:         info.Id,
+		Token:      token,
+		ExpireTime: info.ExpireTime,
+	}, "", 0
+}
+
+func (s *DevopsServer) ApiTokensRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.ApiTokensInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	username := user.Username
+	if user.SuperUser {
+		username = ""
+	}
+
+	infos, err := s.deviceStore.QueryApiTokens(username)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	output := types.ApiTokensOutput{
+		Tokens: []types.ApiToken{},
+	}
+	for _, info := range infos {
+		output.Tokens = append(output.Tokens, apiToken(info))
+	}
+
+	return output, "", 0
+}
+
+func (s *DevopsServer) ApiTokenRevokeRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.ApiTokenRevokeInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	info, err := s.deviceStore.QueryApiToken(input.Id)
+	if err != nil {
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if !user.SuperUser && info.Username != user.Username {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	err = s.deviceStore.RevokeApiToken(input.Id)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	after := *info
+	after.Revoked = true
+	s.audit(req, user.Username, types.AuditActionTokenRevoke, uuid.Nil, apiToken(*info), apiToken(after))
+
+	return nil, "", 0
+}
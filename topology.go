@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	devopsmysql "github.com/NpoolDevOps/fbc-devops-service/mysql"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+)
+
+func (s *DevopsServer) DeviceTopologyRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceTopologyInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	infos, _, err := s.deviceStore.QueryDeviceConfigsByFilter(visibleDeviceFilter(user, devopsmysql.DeviceFilter{}))
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	hidden := map[string]bool{}
+	if !user.SuperUser {
+		all, _, err := s.deviceStore.QueryDeviceConfigsByFilter(devopsmysql.DeviceFilter{})
+		if err != nil {
+			return fail(w, types.ErrStorage, err.Error())
+		}
+		for _, info := range all {
+			hidden[info.Spec] = true
+		}
+		for _, info := range infos {
+			delete(hidden, info.Spec)
+		}
+	}
+
+	return buildDeviceTopology(infos, hidden), "", 0
+}
+
+// buildDeviceTopology resolves parent specs of the given devices into a graph.
+// A device may list several parents, so the result is a DAG rather than a
+// tree; parents outside the given devices are reported as unknown. Hidden
+// specs exist but are not visible to the caller, they are left out of the
+// graph without making their children orphans, so those children are roots.
+func buildDeviceTopology(infos []devopsmysql.DeviceConfig, hidden map[string]bool) types.DeviceTopologyOutput {
+	output := types.DeviceTopologyOutput{
+		Nodes:   []types.DeviceTopologyNode{},
+		Roots:   []uuid.UUID{},
+		Orphans: []uuid.UUID{},
+		Cycles:  [][]uuid.UUID{},
+	}
+
+	specs := map[string]uuid.UUID{}
+	for _, info := range infos {
+		specs[info.Spec] = info.Id
+	}
+
+	nodes := map[uuid.UUID]*types.DeviceTopologyNode{}
+	for _, info := range infos {
+		output.Nodes = append(output.Nodes, types.DeviceTopologyNode{
+			Id:       info.Id,
+			Spec:     info.Spec,
+			Role:     info.Role,
+			SubRole:  info.SubRole,
+			Parents:  []uuid.UUID{},
+			Children: []uuid.UUID{},
+		})
+	}
+	for i := range output.Nodes {
+		nodes[output.Nodes[i].Id] = &output.Nodes[i]
+	}
+
+	for _, info := range infos {
+		node := nodes[info.Id]
+		for _, spec := range strings.Split(info.ParentSpec, ",") {
+			spec = strings.TrimSpace(spec)
+			if spec == "" {
+				continue
+			}
+			parent, ok := specs[spec]
+			if !ok && hidden[spec] {
+				continue
+			}
+			if !ok {
+				node.UnknownParents = append(node.UnknownParents, spec)
+				continue
+			}
+			node.Parents = append(node.Parents, parent)
+			nodes[parent].Children = append(nodes[parent].Children, node.Id)
+		}
+	}
+
+	for _, node := range output.Nodes {
+		if len(node.UnknownParents) > 0 {
+			output.Orphans = append(output.Orphans, node.Id)
+		}
+		if len(node.Parents) == 0 && len(node.UnknownParents) == 0 {
+			output.Roots = append(output.Roots, node.Id)
+		}
+	}
+
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	states := map[uuid.UUID]int{}
+	path := []uuid.UUID{}
+
+	var visit func(id uuid.UUID)
+	visit = func(id uuid.UUID) {
+		states[id] = visiting
+		path = append(path, id)
+
+		for _, child := range nodes[id].Children {
+			switch states[child] {
+			case unvisited:
+				visit(child)
+			case visiting:
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == child {
+						cycle := append([]uuid.UUID{}, path[i:]...)
+						output.Cycles = append(output.Cycles, cycle)
+						break
+					}
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		states[id] = visited
+	}
+
+	for _, node := range output.Nodes {
+		if states[node.Id] == unvisited {
+			visit(node.Id)
+		}
+	}
+
+	return output
+}
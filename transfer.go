@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/NpoolDevOps/fbc-devops-service/authprovider"
+	types "github.com/NpoolDevOps/fbc-devops-service/types"
+	"github.com/google/uuid"
+	"golang.org/x/xerrors"
+)
+
+func (s *DevopsServer) DeviceTransferRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceTransferInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	if input.DeviceID == uuid.Nil {
+		return fail(w, types.ErrInvalidParam, "device id is must")
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeWrite)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	config, err := s.deviceStore.QueryDeviceConfig(input.DeviceID)
+	if err != nil {
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if !user.SuperUser && config.Owner != user.Username {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	if config.Decommissioned {
+		return fail(w, types.ErrDeviceDecommissioned, "device is decommissioned")
+	}
+
+	owner := config.Owner
+	currentUser := config.CurrentUser
+	manager := config.Manager
+
+	for _, target := range []struct {
+		username string
+		field    *string
+	}{
+		{input.Owner, &owner},
+		{input.CurrentUser, &currentUser},
+		{input.Manager, &manager},
+	} {
+		if target.username == "" || target.username == *target.field {
+			continue
+		}
+		err = s.authenticator.ValidateUsername(input.AuthCode, target.username)
+		if xerrors.Is(err, authprovider.ErrCredentialsRequired) {
+			return fail(w, types.ErrAuthRequired, "auth code is must to validate users")
+		}
+		if err != nil {
+			return fail(w, types.ErrInvalidParam, err.Error())
+		}
+		*target.field = target.username
+	}
+
+	if owner == config.Owner && currentUser == config.CurrentUser && manager == config.Manager {
+		return fail(w, types.ErrInvalidParam, "nothing to transfer")
+	}
+
+	before := s.deviceSnapshot(input.DeviceID)
+
+	err = s.deviceStore.TransferDevice(input.DeviceID, owner, currentUser, manager, user.Username)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	s.audit(req, user.Username, types.AuditActionTransfer,
+		input.DeviceID, before, s.deviceSnapshot(input.DeviceID))
+
+	output := types.DeviceCommonOutput{}
+	output.Id = input.DeviceID
+
+	return output, "", 0
+}
+
+func (s *DevopsServer) DeviceOwnershipsRequest(w http.ResponseWriter, req *http.Request) (interface{}, string, int) {
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	input := types.DeviceOwnershipsInput{}
+	err = json.Unmarshal(b, &input)
+	if err != nil {
+		return fail(w, types.ErrInvalidRequest, err.Error())
+	}
+
+	user, err := s.authenticate(req, input.AuthCode, types.TokenScopeRead)
+	if err != nil {
+		return fail(w, types.ErrUnauthenticated, err.Error())
+	}
+
+	config, err := s.deviceStore.QueryDeviceConfig(input.DeviceID)
+	if err != nil {
+		return fail(w, types.ErrNotFound, err.Error())
+	}
+
+	if !canAccessDevice(user, config) {
+		return fail(w, types.ErrPermissionDenied, "permission denied")
+	}
+
+	infos, err := s.deviceStore.QueryDeviceOwnerships(input.DeviceID)
+	if err != nil {
+		return fail(w, types.ErrStorage, err.Error())
+	}
+
+	output := types.DeviceOwnershipsOutput{
+		Ownerships: []types.DeviceOwnership{},
+	}
+	for _, info := range infos {
+		output.Ownerships = append(output.Ownerships, types.DeviceOwnership{
+			Owner:       info.Owner,
+			CurrentUser: info.CurrentUser,
+			Manager:     info.Manager,
+			PrevOwner:   info.PrevOwner,
+			PrevUser:    info.PrevUser,
+			PrevManager: info.PrevManager,
+			Operator:    info.Operator,
+			CreateTime:  info.CreateTime,
+		})
+	}
+
+	return output, "", 0
+}
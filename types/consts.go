@@ -10,4 +10,44 @@ const (
 	DevopsAlertMgrAddressAPI = "/api/v0/device/alertmgraddr"
 	DevopsAuthText           = "FBC DevOps Server - @Copyright NPool COP."
 	MyDevicesMetricsAPI      = "/api/v0/device/metrics"
+	DeviceDriftsAPI          = "/api/v0/device/drifts"
+	DeviceDecommissionAPI    = "/api/v0/device/decommission"
+	DeviceTransferAPI        = "/api/v0/device/transfer"
+	DeviceOwnershipsAPI      = "/api/v0/device/ownerships"
+	DeviceRolesAPI           = "/api/v0/device/roles"
+	DeviceRoleCreateAPI      = "/api/v0/device/role/create"
+	DeviceRoleUpdateAPI      = "/api/v0/device/role/update"
+	DeviceRoleDeleteAPI      = "/api/v0/device/role/delete"
+	DeviceTopologyAPI        = "/api/v0/device/topology"
+	DeviceDetailAPI          = "/api/v0/device/detail"
+	ApiTokenCreateAPI        = "/api/v0/device/token/create"
+	ApiTokensAPI             = "/api/v0/device/tokens"
+	ApiTokenRevokeAPI        = "/api/v0/device/token/revoke"
+	AuditLogsAPI             = "/api/v0/device/audit/logs"
+	MaintenanceCreateAPI     = "/api/v0/device/maintenance/create"
+	MaintenanceCancelAPI     = "/api/v0/device/maintenance/cancel"
+	MaintenancesAPI          = "/api/v0/device/maintenances"
+	DeviceSecretRotateAPI    = "/api/v0/device/secret/rotate"
+)
+
+const (
+	TokenScopeRead  = "read"
+	TokenScopeWrite = "write"
+)
+
+const (
+	AuditActionRegister            = "register"
+	AuditActionMaintain            = "maintain"
+	AuditActionDecommission        = "decommission"
+	AuditActionTransfer            = "transfer"
+	AuditActionAlertMgrUpdate      = "alertmgr_update"
+	AuditActionRoleCreate          = "role_create"
+	AuditActionRoleUpdate          = "role_update"
+	AuditActionRoleDelete          = "role_delete"
+	AuditActionTokenCreate         = "token_create"
+	AuditActionTokenRevoke         = "token_revoke"
+	AuditActionMaintenanceSchedule = "maintenance_schedule"
+	AuditActionMaintenanceCancel   = "maintenance_cancel"
+	AuditActionSecretIssue         = "secret_issue"
+	AuditActionSecretRotate        = "secret_rotate"
 )
@@ -0,0 +1,67 @@
+package types
+
+import (
+	"net/http"
+)
+
+// ErrorCode is an entry of the error catalog shared by the server and its
+// clients. Code is what handlers put into the response, it never changes once
+// released; Reason is its machine readable name.
+type ErrorCode struct {
+	Code       int    `json:"code"`
+	Reason     string `json:"reason"`
+	HttpStatus int    `json:"-"`
+}
+
+const ErrorReasonHeader = "X-Devops-Error-Reason"
+
+var (
+	ErrInvalidRequest       = ErrorCode{-1001, "invalid_request", http.StatusBadRequest}
+	ErrInvalidParam         = ErrorCode{-1002, "invalid_param", http.StatusBadRequest}
+	ErrAuthRequired         = ErrorCode{-1003, "auth_required", http.StatusUnauthorized}
+	ErrUnauthenticated      = ErrorCode{-1004, "unauthenticated", http.StatusUnauthorized}
+	ErrPermissionDenied     = ErrorCode{-1005, "permission_denied", http.StatusForbidden}
+	ErrInvalidSignature     = ErrorCode{-1006, "invalid_signature", http.StatusUnauthorized}
+	ErrNotFound             = ErrorCode{-1007, "not_found", http.StatusNotFound}
+	ErrConflict             = ErrorCode{-1008, "conflict", http.StatusConflict}
+	ErrDeviceDecommissioned = ErrorCode{-1009, "device_decommissioned", http.StatusGone}
+	ErrBatchFailed          = ErrorCode{-1010, "batch_failed", http.StatusConflict}
+	ErrStorage              = ErrorCode{-1011, "storage_error", http.StatusInternalServerError}
+	ErrCache                = ErrorCode{-1012, "cache_error", http.StatusInternalServerError}
+	ErrUpstream             = ErrorCode{-1013, "upstream_error", http.StatusBadGateway}
+	ErrInternal             = ErrorCode{-1014, "internal_error", http.StatusInternalServerError}
+	ErrUnknown              = ErrorCode{-1099, "unknown", http.StatusInternalServerError}
+)
+
+var errorCatalog = map[int]ErrorCode{}
+
+func init() {
+	for _, code := range []ErrorCode{
+		ErrInvalidRequest,
+		ErrInvalidParam,
+		ErrAuthRequired,
+		ErrUnauthenticated,
+		ErrPermissionDenied,
+		ErrInvalidSignature,
+		ErrNotFound,
+		ErrConflict,
+		ErrDeviceDecommissioned,
+		ErrBatchFailed,
+		ErrStorage,
+		ErrCache,
+		ErrUpstream,
+		ErrInternal,
+		ErrUnknown,
+	} {
+		errorCatalog[code.Code] = code
+	}
+}
+
+// ErrorCodeOf maps a response code back to its catalog entry. Codes out of the
+// catalog, e.g. the ones produced by http-daemon itself, map to ErrUnknown.
+func ErrorCodeOf(code int) ErrorCode {
+	if errorCode, ok := errorCatalog[code]; ok {
+		return errorCode
+	}
+	return ErrUnknown
+}
@@ -2,6 +2,7 @@ package types
 
 import (
 	"github.com/google/uuid"
+	"time"
 )
 
 type DeviceRegisterInput struct {
@@ -40,6 +41,7 @@ type DeviceCommonOutput struct {
 
 type DeviceRegisterOutput struct {
 	DeviceCommonOutput
+	Secret string `json:"secret,omitempty"`
 }
 
 type DeviceReportInput struct {
@@ -57,13 +59,30 @@ type DeviceReportOutput struct {
 	DeviceCommonOutput
 }
 
+type DeviceListFilter struct {
+	Page                  int    `json:"page,omitempty"`
+	Limit                 int    `json:"limit,omitempty"`
+	Role                  string `json:"role,omitempty"`
+	SubRole               string `json:"sub_role,omitempty"`
+	Owner                 string `json:"owner,omitempty"`
+	ParentSpec            string `json:"parent_spec,omitempty"`
+	OsSpec                string `json:"os_spec,omitempty"`
+	Maintaining           *bool  `json:"maintaining,omitempty"`
+	Offline               *bool  `json:"offline,omitempty"`
+	IncludeDecommissioned bool   `json:"include_decommissioned"`
+	SortBy                string `json:"sort_by,omitempty"`
+	SortDesc              bool   `json:"sort_desc,omitempty"`
+}
+
 type MyDevicesByAuthInput struct {
 	AuthCode string `json:"auth_code"`
+	DeviceListFilter
 }
 
 type MyDevicesByUsernameInput struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	DeviceListFilter
 }
 
 type DeviceAttribute struct {
@@ -76,21 +95,46 @@ type DeviceAttribute struct {
 	ParentSpec         []string `json:"parent_spec"`
 	Maintaining        bool     `json:"maintaining"`
 	Offline            bool     `json:"offline"`
+	Decommissioned     bool     `json:"decommissioned"`
 }
 
 type MyDevicesOutput struct {
 	Devices []DeviceAttribute `json:"devices"`
+	Total   int               `json:"total"`
+	Page    int               `json:"page,omitempty"`
+	Limit   int               `json:"limit,omitempty"`
+}
+
+type DeviceSelector struct {
+	Role       string `json:"role,omitempty"`
+	SubRole    string `json:"sub_role,omitempty"`
+	ParentSpec string `json:"parent_spec,omitempty"`
 }
 
 type MaintainingInput struct {
-	AuthCode    string    `json:"auth_code"`
-	Maintaining bool      `json:"maintaining"`
-	DeviceID    uuid.UUID `json:"device_id"`
+	AuthCode    string          `json:"auth_code"`
+	Maintaining bool            `json:"maintaining"`
+	DeviceID    uuid.UUID       `json:"device_id"`
+	DeviceIDs   []uuid.UUID     `json:"device_ids,omitempty"`
+	Selector    *DeviceSelector `json:"selector,omitempty"`
+}
+
+type DeviceMaintainResult struct {
+	DeviceID uuid.UUID `json:"device_id"`
+	Success  bool      `json:"success"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type MaintainingOutput struct {
+	Results []DeviceMaintainResult `json:"results"`
 }
 
 type MetricInput struct {
 	Metrics  []string `json:"metrics"`
 	AuthCode string   `json:"auth_code"`
+	Start    int64    `json:"start,omitempty"`
+	End      int64    `json:"end,omitempty"`
+	Step     string   `json:"step,omitempty"`
 }
 
 type Outresp struct {
@@ -98,12 +142,271 @@ type Outresp struct {
 	Metric     []MyMetric `json:"metric"`
 }
 
+type MetricSample struct {
+	Timestamp float64 `json:"timestamp"`
+	Value     string  `json:"value"`
+}
+
 type MyMetric struct {
-	Instance string `json:"instance"`
-	Job      string `json:"job"`
-	Value    string `json:"value"`
+	Instance string         `json:"instance"`
+	Job      string         `json:"job"`
+	Value    string         `json:"value"`
+	Values   []MetricSample `json:"values,omitempty"`
 }
 
 type MetricOutput struct {
 	MetricsValue []Outresp `json:"metrics_value"`
 }
+
+type AlertMgrAddress struct {
+	Id         uuid.UUID `json:"id"`
+	Address    string    `json:"address"`
+	Role       string    `json:"role"`
+	SubRole    string    `json:"sub_role"`
+	ParentSpec string    `json:"parent_spec"`
+}
+
+type DevopsAlertMgrAddressPostInput struct {
+	AuthCode string `json:"auth_code"`
+	AlertMgrAddress
+}
+
+type DevopsAlertMgrAddressPostOutput struct {
+	DeviceCommonOutput
+}
+
+type DevopsAlertMgrAddressGetInput struct {
+	AuthCode    string    `json:"auth_code"`
+	Id          uuid.UUID `json:"id"`
+	Role        string    `json:"role"`
+	SubRole     string    `json:"sub_role"`
+	ParentSpecs []string  `json:"parent_specs"`
+}
+
+type DevopsAlertMgrAddressGetOutput struct {
+	Address   string            `json:"address,omitempty"`
+	Addresses []AlertMgrAddress `json:"addresses,omitempty"`
+}
+
+type DeviceDriftsInput struct {
+	AuthCode string    `json:"auth_code"`
+	DeviceID uuid.UUID `json:"device_id"`
+}
+
+type DeviceDrift struct {
+	Id         uuid.UUID `json:"id"`
+	DeviceID   uuid.UUID `json:"device_id"`
+	Item       string    `json:"item"`
+	Expected   int       `json:"expected"`
+	Actual     int       `json:"actual"`
+	Recovered  bool      `json:"recovered"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+type DeviceDriftsOutput struct {
+	Drifts []DeviceDrift `json:"drifts"`
+}
+
+type DeviceDecommissionInput struct {
+	AuthCode string    `json:"auth_code"`
+	DeviceID uuid.UUID `json:"device_id"`
+	Reason   string    `json:"reason"`
+}
+
+type DeviceSecretRotateInput struct {
+	AuthCode string    `json:"auth_code"`
+	DeviceID uuid.UUID `json:"device_id"`
+}
+
+type DeviceSecretRotateOutput struct {
+	DeviceCommonOutput
+	Secret string `json:"secret"`
+}
+
+type DeviceTransferInput struct {
+	AuthCode    string    `json:"auth_code"`
+	DeviceID    uuid.UUID `json:"device_id"`
+	Owner       string    `json:"owner"`
+	CurrentUser string    `json:"current_user"`
+	Manager     string    `json:"manager"`
+}
+
+type DeviceOwnershipsInput struct {
+	AuthCode string    `json:"auth_code"`
+	DeviceID uuid.UUID `json:"device_id"`
+}
+
+type DeviceOwnership struct {
+	Owner       string    `json:"owner"`
+	CurrentUser string    `json:"current_user"`
+	Manager     string    `json:"manager"`
+	PrevOwner   string    `json:"prev_owner"`
+	PrevUser    string    `json:"prev_user"`
+	PrevManager string    `json:"prev_manager"`
+	Operator    string    `json:"operator"`
+	CreateTime  time.Time `json:"create_time"`
+}
+
+type DeviceOwnershipsOutput struct {
+	Ownerships []DeviceOwnership `json:"ownerships"`
+}
+
+type DeviceRole struct {
+	Role     string   `json:"role"`
+	SubRoles []string `json:"sub_roles"`
+}
+
+type DeviceRoleInput struct {
+	AuthCode string `json:"auth_code"`
+	DeviceRole
+}
+
+type DeviceRolesInput struct {
+	AuthCode string `json:"auth_code"`
+}
+
+type DeviceRolesOutput struct {
+	Roles []DeviceRole `json:"roles"`
+}
+
+type DeviceTopologyInput struct {
+	AuthCode string `json:"auth_code"`
+}
+
+type DeviceTopologyNode struct {
+	Id             uuid.UUID   `json:"id"`
+	Spec           string      `json:"spec"`
+	Role           string      `json:"role"`
+	SubRole        string      `json:"sub_role"`
+	Parents        []uuid.UUID `json:"parents"`
+	Children       []uuid.UUID `json:"children"`
+	UnknownParents []string    `json:"unknown_parents,omitempty"`
+}
+
+type DeviceTopologyOutput struct {
+	Nodes   []DeviceTopologyNode `json:"nodes"`
+	Roots   []uuid.UUID          `json:"roots"`
+	Orphans []uuid.UUID          `json:"orphans"`
+	Cycles  [][]uuid.UUID        `json:"cycles"`
+}
+
+type DeviceDetailInput struct {
+	AuthCode     string    `json:"auth_code"`
+	DeviceID     uuid.UUID `json:"device_id"`
+	HistoryLimit int       `json:"history_limit,omitempty"`
+}
+
+type DeviceReportRecord struct {
+	DeviceReportInput
+	CreateTime time.Time `json:"create_time"`
+}
+
+type DeviceStatusChange struct {
+	Offline    bool      `json:"offline"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+type DeviceDetailOutput struct {
+	Device        DeviceAttribute      `json:"device"`
+	Reports       []DeviceReportRecord `json:"reports"`
+	StatusChanges []DeviceStatusChange `json:"status_changes"`
+}
+
+type ApiTokenCreateInput struct {
+	AuthCode  string   `json:"auth_code"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	SuperUser bool     `json:"super_user"`
+	Expire    int64    `json:"expire"`
+}
+
+type ApiTokenCreateOutput struct {
+	Id         uuid.UUID `json:"id"`
+	Token      string    `json:"token"`
+	ExpireTime time.Time `json:"expire_time"`
+}
+
+type ApiToken struct {
+	Id         uuid.UUID `json:"id"`
+	Name       string    `json:"name"`
+	Username   string    `json:"username"`
+	SuperUser  bool      `json:"super_user"`
+	Scopes     []string  `json:"scopes"`
+	Revoked    bool      `json:"revoked"`
+	ExpireTime time.Time `json:"expire_time"`
+	CreateTime time.Time `json:"create_time"`
+}
+
+type ApiTokensInput struct {
+	AuthCode string `json:"auth_code"`
+}
+
+type ApiTokensOutput struct {
+	Tokens []ApiToken `json:"tokens"`
+}
+
+type ApiTokenRevokeInput struct {
+	AuthCode string    `json:"auth_code"`
+	Id       uuid.UUID `json:"id"`
+}
+
+type AuditLogsInput struct {
+	AuthCode string    `json:"auth_code"`
+	DeviceID uuid.UUID `json:"device_id"`
+	Username string    `json:"username"`
+	Start    int64     `json:"start,omitempty"`
+	End      int64     `json:"end,omitempty"`
+	Limit    int       `json:"limit,omitempty"`
+}
+
+type AuditFieldDiff struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+type AuditLog struct {
+	Id         uuid.UUID                 `json:"id"`
+	Actor      string                    `json:"actor"`
+	Action     string                    `json:"action"`
+	DeviceID   uuid.UUID                 `json:"device_id"`
+	Diff       map[string]AuditFieldDiff `json:"diff"`
+	SourceIp   string                    `json:"source_ip"`
+	CreateTime time.Time                 `json:"create_time"`
+}
+
+type AuditLogsOutput struct {
+	Logs []AuditLog `json:"logs"`
+}
+
+type MaintenanceCreateInput struct {
+	AuthCode string    `json:"auth_code"`
+	DeviceID uuid.UUID `json:"device_id"`
+	Start    int64     `json:"start"`
+	End      int64     `json:"end"`
+	Reason   string    `json:"reason"`
+}
+
+type MaintenanceCancelInput struct {
+	AuthCode string    `json:"auth_code"`
+	Id       uuid.UUID `json:"id"`
+}
+
+type MaintenancesInput struct {
+	AuthCode string    `json:"auth_code"`
+	DeviceID uuid.UUID `json:"device_id"`
+}
+
+type MaintenanceWindow struct {
+	Id        uuid.UUID `json:"id"`
+	DeviceID  uuid.UUID `json:"device_id"`
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Reason    string    `json:"reason"`
+	Operator  string    `json:"operator"`
+	Status    string    `json:"status"`
+	SilenceId string    `json:"silence_id,omitempty"`
+}
+
+type MaintenancesOutput struct {
+	Windows []MaintenanceWindow `json:"windows"`
+}